@@ -0,0 +1,158 @@
+package numbers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"time"
+)
+
+var (
+	replyNew = []byte("NEW\n")
+	replyDup = []byte("DUP\n")
+)
+
+// NewAckTCPController returns a TCPController that reads the lines as the one returned by NewTCPController,
+// and writes back a line for every one of them, in order, once the NumberStore has answered it:
+// NEW for a new number, DUP for a duplicate and ERR <reason> for a rejected line.
+// Invalid lines are always replied, the policy only says if the client is disconnected for them.
+func NewAckTCPController(policy InputPolicy, rejections *Rejections) TCPController {
+	return v1(NewAckTCPControllerV2(policy, rejections))
+}
+
+// NewAckTCPControllerV2 works as NewAckTCPController, returning a TCPControllerV2.
+func NewAckTCPControllerV2(policy InputPolicy, rejections *Rejections) TCPControllerV2 {
+	rejections.addPolicy(policy)
+	return func(ctx context.Context, c net.Conn, numbers chan *Batch) error {
+		return ackNumbers(ctx, c, numbers, policy, rejections)
+	}
+}
+
+func ackNumbers(ctx context.Context, c net.Conn, numbers chan *Batch, policy InputPolicy, rejections *Rejections) error {
+	reader := bufio.NewReader(c)
+	acks := &acker{c: c, writer: bufio.NewWriter(c), out: numbers, done: ctx.Done(), batch: NewBatch()}
+	defer acks.release()
+	invalid := newInvalidLines(policy)
+	for {
+		if reader.Buffered() < lineLength || acks.batch.full() {
+			if err := acks.flush(); err != nil {
+				return err
+			}
+		}
+		number, terminateLine, token, err := nextLine(c, reader, policy)
+		if _, rejected := err.(*LineError); rejected {
+			acks.reject(err)
+			if err := policy.check(c, err, invalid, rejections); err != nil {
+				return acks.stop(err)
+			}
+			continue
+		}
+		if err == io.EOF {
+			return acks.stop(nil)
+		}
+		if err != nil {
+			return acks.stop(err)
+		}
+		if terminateLine {
+			if err := policy.terminate(c, token); err != nil {
+				return acks.stop(err)
+			}
+			if err := acks.flush(); err != nil {
+				return err
+			}
+			return TERMINATED
+		}
+		acks.add(number)
+	}
+}
+
+// ackLine is a line waiting for its reply, either the position of its number in the batch
+// or the error it was rejected with.
+type ackLine struct {
+	position int
+	err      error
+}
+
+// acker sends the numbers of a client to the NumberStore in batches and replies its lines in order,
+// once the NumberStore has answered them.
+type acker struct {
+	c      net.Conn
+	writer *bufio.Writer
+	out    chan *Batch
+	done   <-chan struct{}
+	batch  *Batch
+	lines  []ackLine
+}
+
+// add adds the number to the current batch.
+func (a *acker) add(number int) {
+	a.lines = append(a.lines, ackLine{position: len(a.batch.Numbers)})
+	a.batch.Numbers = append(a.batch.Numbers, number)
+}
+
+// reject adds a line rejected with err.
+func (a *acker) reject(err error) {
+	a.lines = append(a.lines, ackLine{err: err})
+}
+
+// flush replies all the pending lines. Returns TERMINATED if done is closed, once they are replied.
+func (a *acker) flush() error {
+	if err := a.reply(); err != nil {
+		return err
+	}
+	select {
+	case <-a.done:
+		return TERMINATED
+	default:
+		return nil
+	}
+}
+
+// reply sends the current batch, waits for the NumberStore to answer it and replies all the pending lines.
+func (a *acker) reply() error {
+	if len(a.lines) == 0 {
+		return nil
+	}
+	var unique []bool
+	if len(a.batch.Numbers) > 0 {
+		verdicts := a.batch.expectVerdicts()
+		a.out <- a.batch
+		a.batch = NewBatch()
+		<-verdicts.done
+		unique = verdicts.unique
+	}
+	for _, line := range a.lines {
+		switch {
+		case line.err != nil:
+			fmt.Fprintf(a.writer, "ERR %v\n", line.err)
+		case unique[line.position]:
+			a.writer.Write(replyNew)
+		default:
+			a.writer.Write(replyDup)
+		}
+	}
+	a.lines = a.lines[:0]
+	if err := a.c.SetWriteDeadline(time.Now().Add(readDeadline)); err != nil {
+		return errors.Wrap(err, "SetWriteDeadline")
+	}
+	if err := a.writer.Flush(); err != nil {
+		return errors.Wrap(err, "write replies")
+	}
+	return nil
+}
+
+// stop replies the pending lines, so the client knows what happened to them, and returns err.
+func (a *acker) stop(err error) error {
+	if flushErr := a.flush(); flushErr != nil && err == nil {
+		return flushErr
+	}
+	return err
+}
+
+// release returns the current batch to the pool, the acker must not be used after.
+func (a *acker) release() {
+	a.batch.Release()
+}
@@ -0,0 +1,84 @@
+package numbers_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"tgracchus/numbers"
+)
+
+func TestAckTCPController(t *testing.T) {
+	numbersIn := make(chan *numbers.Batch)
+	terminate := make(chan int)
+	out := numbers.ShardedNumberStore(1000, numbers.NewShards(4, func(size int) numbers.Deduplicator { return numbers.NewMapSet() }),
+		[]chan *numbers.Batch{numbersIn}, terminate)
+	go func() {
+		for batch := range out {
+			batch.Release()
+		}
+	}()
+	controller := numbers.NewAckTCPController(numbers.InputPolicy{OnInvalid: numbers.Skip}, nil)
+
+	err, replies := serveController(t, controller, "123456789\n123456789\n12345678a\n987654321\n000000001\n",
+		numbersIn, terminate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "NEW\nDUP\nERR non digit at position 8\nNEW\nNEW\n"
+	if replies != expected {
+		t.Fatal(fmt.Errorf("replies should be: %q not %q", expected, replies))
+	}
+	close(numbersIn)
+}
+
+func TestAckTCPControllerDisconnects(t *testing.T) {
+	numbersIn := make(chan *numbers.Batch)
+	out := numbers.ShardedNumberStore(1000, numbers.NewShards(1, func(size int) numbers.Deduplicator { return numbers.NewMapSet() }),
+		[]chan *numbers.Batch{numbersIn}, make(chan int))
+	go func() {
+		for batch := range out {
+			batch.Release()
+		}
+	}()
+	controller := numbers.NewAckTCPController(numbers.InputPolicy{}, nil)
+
+	err, replies := serveController(t, controller, "123456789\n+12345678\n987654321\n", numbersIn, make(chan int))
+	if !errors.Is(err, numbers.ErrSign) {
+		t.Fatal(fmt.Errorf("error should be: %v not %v", numbers.ErrSign, err))
+	}
+	expected := "NEW\nERR sign at position 0\n"
+	if replies != expected {
+		t.Fatal(fmt.Errorf("replies should be: %q not %q", expected, replies))
+	}
+	close(numbersIn)
+}
+
+func TestAckTCPControllerTerminatedStillAnswers(t *testing.T) {
+	numbersIn := make(chan *numbers.Batch)
+	terminate := make(chan int)
+	out := numbers.ShardedNumberStore(1000, numbers.NewShards(2, func(size int) numbers.Deduplicator { return numbers.NewMapSet() }),
+		[]chan *numbers.Batch{numbersIn}, terminate)
+	stored := make(chan *numbers.Batch, 1)
+	go func() {
+		for batch := range out {
+			stored <- batch
+		}
+	}()
+	controlled := make(chan *numbers.Batch)
+	go func() {
+		batch := <-controlled
+		close(terminate)
+		numbersIn <- batch
+		close(numbersIn)
+	}()
+	controller := numbers.NewAckTCPController(numbers.InputPolicy{}, nil)
+
+	err, replies := serveController(t, controller, "123456789\n", controlled, terminate)
+	if err != numbers.TERMINATED {
+		t.Fatal(fmt.Errorf("error should be: %v not %v", numbers.TERMINATED, err))
+	}
+	if replies != "NEW\n" {
+		t.Fatal(fmt.Errorf("replies should be: %q not %q", "NEW\n", replies))
+	}
+	expectNumber(stored, 123456789, t)
+}
@@ -0,0 +1,178 @@
+package numbers
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Commands of the admin channel, every one is replied with OK or ERR <reason>.
+const (
+	// AdminTerminate terminates the server, as a terminate line does.
+	AdminTerminate = "terminate"
+	// AdminFlush flushes and fsyncs numbers.log.
+	AdminFlush = "flush"
+	// AdminReport sends a report to the reporters right away.
+	AdminReport = "report"
+	// AdminReset resets the statistics of the reports and the counts of rejected lines.
+	AdminReset = "reset"
+	// AdminConnections, followed by a number, changes how many connections are served at the same time.
+	AdminConnections = "connections"
+)
+
+// adminAuth is the prefix of the first line of an admin client, followed by the token.
+const adminAuth = "AUTH "
+
+// ErrUnauthorized is the reply to an admin client without the right token.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// maxAdminLineLength is the longest admin line accepted, AUTH and the token included.
+const maxAdminLineLength = 1024
+
+// ErrAdminLineTooLong is the reply to an admin line over maxAdminLineLength, the client is disconnected.
+var ErrAdminLineTooLong = errors.New("admin line too long")
+
+// admin runs the commands of the admin channel clients.
+type admin struct {
+	token string
+	// terminate terminates the server, terminated is closed once it is.
+	terminate  func()
+	terminated <-chan struct{}
+	syncs      chan chan logSync
+	requests   chan storeRequest
+	// resize changes how many connections are served at the same time.
+	resize func(n int) error
+	logger *log.Logger
+}
+
+// startAdmin listens for admin clients at address, of the tcp or unix network, until stop is closed.
+func startAdmin(network string, address string, a *admin, stop chan int) error {
+	if a.token == "" {
+		return errors.New("the admin channel requires a token")
+	}
+	if network == "unix" {
+		if err := removeStaleSocket(address); err != nil {
+			return err
+		}
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return errors.Wrap(err, "admin listener")
+	}
+	a.logger.Printf("admin channel started at: %s %s", network, address)
+	go func() {
+		<-stop
+		closeListener(l, a.logger)
+	}()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				a.logger.Printf("%v", errors.Wrap(err, "accept admin connection"))
+				return
+			}
+			go a.serve(c)
+		}
+	}()
+	return nil
+}
+
+// serve authenticates the client with its first line, AUTH <token>, and then runs its commands, one per line.
+func (a *admin) serve(c net.Conn) {
+	defer closeConnection(c, a.logger)
+	reader := bufio.NewReaderSize(c, maxAdminLineLength)
+	authenticated := false
+	for {
+		if err := c.SetReadDeadline(time.Now().Add(readDeadline)); err != nil {
+			a.logger.Printf("%v", errors.Wrap(err, "SetReadDeadline"))
+			return
+		}
+		read, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			a.logger.Printf("%v", fmt.Errorf("admin client: %s, %w", c.RemoteAddr().String(), ErrAdminLineTooLong))
+			replyAdmin(c, a.logger, ErrAdminLineTooLong)
+			return
+		}
+		if err != nil {
+			if err != io.EOF {
+				a.logger.Printf("%v", errors.Wrap(err, "read admin command"))
+			}
+			return
+		}
+		line := strings.TrimSpace(string(read))
+		if !authenticated {
+			token := strings.TrimPrefix(line, adminAuth)
+			if !strings.HasPrefix(line, adminAuth) || !validToken([]byte(token), a.token) {
+				a.logger.Printf("%v", fmt.Errorf("admin client: %s, %w", c.RemoteAddr().String(), ErrUnauthorized))
+				replyAdmin(c, a.logger, ErrUnauthorized)
+				return
+			}
+			authenticated = true
+			if err := replyAdmin(c, a.logger, nil); err != nil {
+				return
+			}
+			continue
+		}
+		err = a.run(line)
+		a.logger.Printf("admin client: %s, %s: %v", c.RemoteAddr().String(), line, err)
+		if err := replyAdmin(c, a.logger, err); err != nil || line == AdminTerminate {
+			return
+		}
+	}
+}
+
+// run runs the command, it returns TERMINATED for the commands that need a server not terminated yet.
+func (a *admin) run(command string) error {
+	switch command {
+	case AdminTerminate:
+		a.terminate()
+		return nil
+	case AdminFlush:
+		reply := make(chan logSync)
+		select {
+		case a.syncs <- reply:
+			return (<-reply).err
+		case <-a.terminated:
+			return TERMINATED
+		}
+	case AdminReport, AdminReset:
+		request := storeRequest{reset: command == AdminReset, done: make(chan int)}
+		select {
+		case a.requests <- request:
+			<-request.done
+			return nil
+		case <-a.terminated:
+			return TERMINATED
+		}
+	}
+	if fields := strings.Fields(command); len(fields) == 2 && fields[0] == AdminConnections {
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Errorf("connections should be a number, not %q", fields[1])
+		}
+		return a.resize(n)
+	}
+	return fmt.Errorf("unknown command %q", command)
+}
+
+// replyAdmin replies OK if err is nil, ERR <err> otherwise.
+func replyAdmin(c net.Conn, logger *log.Logger, err error) error {
+	if setErr := c.SetWriteDeadline(time.Now().Add(readDeadline)); setErr != nil {
+		return errors.Wrap(setErr, "SetWriteDeadline")
+	}
+	reply := "OK\n"
+	if err != nil {
+		reply = fmt.Sprintf("ERR %v\n", err)
+	}
+	if _, err := io.WriteString(c, reply); err != nil {
+		logger.Printf("%v", errors.Wrap(err, "reply admin command"))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package numbers_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"tgracchus/numbers"
+	"time"
+)
+
+func TestAdminChannel(t *testing.T) {
+	stopped, conn := startTestServer(t, numbers.Options{
+		Address:      "localhost:4103",
+		AdminNetwork: "unix",
+		AdminAddress: "admin.sock",
+		AdminToken:   "secret",
+	})
+	defer conn.Close()
+	if _, err := conn.Write([]byte("000000001\n000000002\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	admin := dialAdmin(t, "unix", "admin.sock")
+	defer admin.Close()
+	expectAdminReply(t, admin, "AUTH secret", "OK")
+	expectAdminReply(t, admin, numbers.AdminFlush, "OK")
+	expectLogNumbers(t, "000000001", "000000002")
+	expectAdminReply(t, admin, numbers.AdminReport, "OK")
+	expectAdminReply(t, admin, numbers.AdminReset, "OK")
+	expectAdminReply(t, admin, "connections 3", "OK")
+	expectAdminReply(t, admin, "connections 0", "ERR concurrent connections should be between 1 and 65536, not 0")
+	expectAdminReply(t, admin, "connections many", `ERR connections should be a number, not "many"`)
+	expectAdminReply(t, admin, "restart", `ERR unknown command "restart"`)
+	expectAdminReply(t, admin, numbers.AdminTerminate, "OK")
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout while waiting for the server to terminate")
+	}
+}
+
+func TestAdminChannelUnauthorized(t *testing.T) {
+	stopped, conn := startTestServer(t, numbers.Options{
+		Address:      "localhost:4104",
+		AdminNetwork: "tcp",
+		AdminAddress: "localhost:4105",
+		AdminToken:   "secret",
+		InputPolicy:  numbers.InputPolicy{Terminate: numbers.TerminateDisabled},
+	})
+	defer conn.Close()
+	if _, err := conn.Write([]byte("terminate\n")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+		t.Fatal("terminate should be refused on the data port")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	admin := dialAdmin(t, "tcp", "localhost:4105")
+	defer admin.Close()
+	expectAdminReply(t, admin, "AUTH guess", fmt.Sprintf("ERR %v", numbers.ErrUnauthorized))
+
+	admin = dialAdmin(t, "tcp", "localhost:4105")
+	defer admin.Close()
+	if _, err := admin.Write([]byte("AUTH " + strings.Repeat("a", 1019))); err != nil {
+		t.Fatal(err)
+	}
+	admin.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := ioutil.ReadAll(admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := fmt.Sprintf("ERR %v\n", numbers.ErrAdminLineTooLong); string(reply) != expected {
+		t.Fatal(fmt.Errorf("reply to a line without end should be: %q not %q", expected, reply))
+	}
+
+	admin = dialAdmin(t, "tcp", "localhost:4105")
+	defer admin.Close()
+	expectAdminReply(t, admin, "AUTH secret", "OK")
+	expectAdminReply(t, admin, numbers.AdminTerminate, "OK")
+	<-stopped
+}
+
+func dialAdmin(t *testing.T, network string, address string) net.Conn {
+	admin, err := net.Dial(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return admin
+}
+
+// expectAdminReply sends the command to the admin channel and checks its reply.
+func expectAdminReply(t *testing.T, admin net.Conn, command string, expected string) {
+	if _, err := fmt.Fprintf(admin, "%s\n", command); err != nil {
+		t.Fatal(err)
+	}
+	admin.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := bufio.NewReader(admin).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != expected+"\n" {
+		t.Fatal(fmt.Errorf("reply to %s should be: %q not %q", command, expected+"\n", reply))
+	}
+}
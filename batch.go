@@ -0,0 +1,135 @@
+package numbers
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// batchSize is how many numbers a batch holds before it is handed to the next stage.
+const batchSize = 256
+
+// batchLatency is how long a number can wait in a batch that is not full before it is handed to the next stage.
+const batchLatency = 5 * time.Millisecond
+
+// Batch is a group of numbers moving as one through the pipeline, so there is a channel handoff per batch
+// instead of one per number. Batches are pooled, the stage receiving a batch releases it once done with it.
+type Batch struct {
+	Numbers []int
+	// verdicts is set on the batches of a controller waiting for the NumberStore verdict of every number.
+	verdicts *verdicts
+	// positions are the positions of the numbers in the controller batch, once routed. Nil when not routed.
+	positions []int
+}
+
+var batchPool = sync.Pool{
+	New: func() interface{} {
+		return &Batch{Numbers: make([]int, 0, batchSize)}
+	},
+}
+
+// NewBatch returns an empty batch from the pool.
+func NewBatch() *Batch {
+	return batchPool.Get().(*Batch)
+}
+
+// Release empties the batch and returns it to the pool, it must not be used after.
+func (b *Batch) Release() {
+	b.Numbers = b.Numbers[:0]
+	b.verdicts = nil
+	b.positions = b.positions[:0]
+	batchPool.Put(b)
+}
+
+// position returns the position in the controller batch of the number at i.
+func (b *Batch) position(i int) int {
+	if len(b.positions) == 0 {
+		return i
+	}
+	return b.positions[i]
+}
+
+// full returns if the batch should be handed to the next stage because of its size.
+func (b *Batch) full() bool {
+	return len(b.Numbers) >= batchSize
+}
+
+// batcher groups the numbers it is given in batches sent to out. A batch is sent when it is full,
+// when its first number has waited batchLatency or when it is flushed. A batch is sent even if done
+// is closed, so no number added is lost, the cancellation is noticed once it is sent.
+type batcher struct {
+	out     chan *Batch
+	done    <-chan struct{}
+	batch   *Batch
+	started time.Time
+}
+
+func newBatcher(out chan *Batch, done <-chan struct{}) *batcher {
+	return &batcher{out: out, done: done, batch: NewBatch()}
+}
+
+// add adds the number to the current batch and sends it if it is due.
+func (b *batcher) add(number int) error {
+	if len(b.batch.Numbers) == 0 {
+		b.started = time.Now()
+	}
+	b.batch.Numbers = append(b.batch.Numbers, number)
+	if b.batch.full() || time.Since(b.started) >= batchLatency {
+		return b.flush()
+	}
+	return nil
+}
+
+// flush sends the current batch if it is not empty. Returns TERMINATED if done is closed.
+func (b *batcher) flush() error {
+	if len(b.batch.Numbers) > 0 {
+		b.out <- b.batch
+		b.batch = NewBatch()
+	}
+	select {
+	case <-b.done:
+		return TERMINATED
+	default:
+		return nil
+	}
+}
+
+// stop flushes the current batch, so the numbers added before err are not lost, and returns err.
+func (b *batcher) stop(err error) error {
+	if flushErr := b.flush(); flushErr != nil && err == nil {
+		return flushErr
+	}
+	return err
+}
+
+// release returns the current batch to the pool, the batcher must not be used after.
+func (b *batcher) release() {
+	b.batch.Release()
+}
+
+// verdicts are the NumberStore answers to a batch, new or duplicate for every number.
+// A batch split by route is answered once all its parts are deduplicated.
+type verdicts struct {
+	unique []bool
+	// pending are the parts of the batch not yet deduplicated.
+	pending int32
+	done    chan int
+}
+
+// expectVerdicts makes the NumberStore answer the batch, returns the verdicts to wait for.
+func (b *Batch) expectVerdicts() *verdicts {
+	b.verdicts = &verdicts{unique: make([]bool, len(b.Numbers)), pending: 1, done: make(chan int)}
+	return b.verdicts
+}
+
+// split records that the batch is sent in parts more parts instead of one.
+func (v *verdicts) split(parts int) {
+	atomic.AddInt32(&v.pending, int32(parts-1))
+}
+
+// answered records that a part of the batch is deduplicated, done is closed once all of them are.
+func (v *verdicts) answered() {
+	if atomic.AddInt32(&v.pending, -1) == 0 {
+		close(v.done)
+	}
+}
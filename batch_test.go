@@ -0,0 +1,62 @@
+package numbers_test
+
+import (
+	"testing"
+	"tgracchus/numbers"
+)
+
+func TestBatchRelease(t *testing.T) {
+	batch := batchOf(1, 2, 3)
+	batch.Release()
+	if len(numbers.NewBatch().Numbers) != 0 {
+		t.Fatal("a new batch should be empty")
+	}
+}
+
+// BenchmarkTransportPerNumber moves every number through two channel hops, as the pipeline did before batches.
+func BenchmarkTransportPerNumber(b *testing.B) {
+	first := make(chan int)
+	second := make(chan int)
+	go func() {
+		for number := range first {
+			second <- number
+		}
+		close(second)
+	}()
+	go func() {
+		for n := 0; n < b.N; n++ {
+			first <- n
+		}
+		close(first)
+	}()
+	for range second {
+	}
+}
+
+// BenchmarkTransportBatched moves the numbers through two channel hops in pooled batches.
+func BenchmarkTransportBatched(b *testing.B) {
+	b.ReportAllocs()
+	first := make(chan *numbers.Batch)
+	second := make(chan *numbers.Batch)
+	go func() {
+		for batch := range first {
+			second <- batch
+		}
+		close(second)
+	}()
+	go func() {
+		batch := numbers.NewBatch()
+		for n := 0; n < b.N; n++ {
+			batch.Numbers = append(batch.Numbers, n)
+			if len(batch.Numbers) == 256 {
+				first <- batch
+				batch = numbers.NewBatch()
+			}
+		}
+		first <- batch
+		close(first)
+	}()
+	for batch := range second {
+		batch.Release()
+	}
+}
@@ -0,0 +1,154 @@
+package numbers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"time"
+)
+
+// BinaryHandshake is what a client of the binary protocol sends first, the magic followed by the version.
+// The server replies with the same bytes when it speaks that version, and closes the connection otherwise.
+var BinaryHandshake = []byte{'N', 'B', 'I', 'N', 1}
+
+// Frame types of the binary protocol. A frame is its type, its payload length as a big endian uint32 and its payload.
+const (
+	// FrameNumbers carries numbers packed as big endian uint32, its length is a multiple of 4.
+	FrameNumbers byte = 1
+	// FrameTerminate terminates the server, its payload is the terminate token, if any.
+	FrameTerminate byte = 2
+)
+
+// frameHeaderLength is the length of the type and the length of a frame.
+const frameHeaderLength = 5
+
+// MaxFrameLength is the longest payload accepted in a frame.
+const MaxFrameLength = 4 * 16384
+
+// maxTokenLength is the longest payload accepted in a terminate frame.
+const maxTokenLength = 256
+
+// Errors of the clients not following the binary protocol.
+var (
+	ErrHandshake     = errors.New("wrong handshake")
+	ErrFrame         = errors.New("wrong frame")
+	ErrNumberTooHigh = errors.New("number over 999999999")
+)
+
+// BinaryTCPController is a TCPController speaking the binary protocol, the numbers of every frame are sent to
+// the numbers channel. A client not following the protocol is disconnected.
+func BinaryTCPController(ctx context.Context, c net.Conn, numbers chan *Batch, terminate chan int) error {
+	return v1(BinaryTCPControllerV2)(ctx, c, numbers, terminate)
+}
+
+// BinaryTCPControllerV2 works as BinaryTCPController until ctx is canceled.
+func BinaryTCPControllerV2(ctx context.Context, c net.Conn, numbers chan *Batch) error {
+	return readFrames(ctx, c, numbers, InputPolicy{})
+}
+
+// NewBinaryTCPController returns a TCPController working as BinaryTCPController that handles the terminate frames
+// as the policy says.
+func NewBinaryTCPController(policy InputPolicy) TCPController {
+	return v1(NewBinaryTCPControllerV2(policy))
+}
+
+// NewBinaryTCPControllerV2 works as NewBinaryTCPController, returning a TCPControllerV2.
+func NewBinaryTCPControllerV2(policy InputPolicy) TCPControllerV2 {
+	return func(ctx context.Context, c net.Conn, numbers chan *Batch) error {
+		return readFrames(ctx, c, numbers, policy)
+	}
+}
+
+func readFrames(ctx context.Context, c net.Conn, numbers chan *Batch, policy InputPolicy) error {
+	reader := bufio.NewReaderSize(c, MaxFrameLength+frameHeaderLength)
+	if err := binaryHandshake(c, reader); err != nil {
+		return err
+	}
+	batches := newBatcher(numbers, ctx.Done())
+	defer batches.release()
+	for {
+		if reader.Buffered() < frameHeaderLength {
+			if err := batches.flush(); err != nil {
+				return err
+			}
+		}
+		if err := c.SetReadDeadline(time.Now().Add(readDeadline)); err != nil {
+			return batches.stop(errors.Wrap(err, "SetReadDeadline"))
+		}
+		header, err := reader.Peek(frameHeaderLength)
+		if err == io.EOF && len(header) == 0 {
+			return batches.stop(nil)
+		}
+		if err != nil {
+			return batches.stop(errors.Wrap(err, "read frame header"))
+		}
+		frameType, length := header[0], binary.BigEndian.Uint32(header[1:])
+		if err := checkFrame(frameType, length); err != nil {
+			return batches.stop(fmt.Errorf("client: %s, %w", c.RemoteAddr().String(), err))
+		}
+		frame, err := reader.Peek(frameHeaderLength + int(length))
+		if err != nil {
+			return batches.stop(errors.Wrap(err, "read frame"))
+		}
+		if frameType == FrameTerminate {
+			if err := policy.terminate(c, frame[frameHeaderLength:]); err != nil {
+				return batches.stop(err)
+			}
+			if err := batches.flush(); err != nil {
+				return err
+			}
+			return TERMINATED
+		}
+		for payload := frame[frameHeaderLength:]; len(payload) > 0; payload = payload[4:] {
+			number := binary.BigEndian.Uint32(payload)
+			if number >= maxNumbers {
+				return batches.stop(fmt.Errorf("client: %s, %w: %d", c.RemoteAddr().String(), ErrNumberTooHigh, number))
+			}
+			if err := batches.add(int(number)); err != nil {
+				return err
+			}
+		}
+		if _, err := reader.Discard(len(frame)); err != nil {
+			return batches.stop(errors.Wrap(err, "discard frame"))
+		}
+	}
+}
+
+// binaryHandshake reads the handshake of the client and replies it when it is the expected one.
+func binaryHandshake(c net.Conn, reader *bufio.Reader) error {
+	if err := c.SetReadDeadline(time.Now().Add(readDeadline)); err != nil {
+		return errors.Wrap(err, "SetReadDeadline")
+	}
+	handshake := make([]byte, len(BinaryHandshake))
+	if _, err := io.ReadFull(reader, handshake); err != nil {
+		return errors.Wrap(err, "read handshake")
+	}
+	if !bytes.Equal(handshake, BinaryHandshake) {
+		return fmt.Errorf("client: %s, %w: %q", c.RemoteAddr().String(), ErrHandshake, handshake)
+	}
+	if err := c.SetWriteDeadline(time.Now().Add(readDeadline)); err != nil {
+		return errors.Wrap(err, "SetWriteDeadline")
+	}
+	if _, err := c.Write(BinaryHandshake); err != nil {
+		return errors.Wrap(err, "reply handshake")
+	}
+	return nil
+}
+
+// checkFrame returns an ErrFrame error if the frame header is not valid.
+func checkFrame(frameType byte, length uint32) error {
+	switch {
+	case frameType == FrameNumbers && (length%4 != 0 || length > MaxFrameLength):
+		return fmt.Errorf("%w, numbers frame of %d bytes", ErrFrame, length)
+	case frameType == FrameTerminate && length > maxTokenLength:
+		return fmt.Errorf("%w, terminate frame of %d bytes", ErrFrame, length)
+	case frameType != FrameNumbers && frameType != FrameTerminate:
+		return fmt.Errorf("%w, unknown type %d", ErrFrame, frameType)
+	}
+	return nil
+}
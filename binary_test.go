@@ -0,0 +1,151 @@
+package numbers_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"tgracchus/numbers"
+	"time"
+)
+
+func TestBinaryTCPController(t *testing.T) {
+	wire := string(numbers.BinaryHandshake) + numbersFrame(123456789, 1, 999999999) + numbersFrame() + numbersFrame(42)
+
+	received, err, replies := runController(t, numbers.BinaryTCPController, wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received != "[123456789 1 999999999 42]" {
+		t.Fatal(fmt.Errorf("received should be: [123456789 1 999999999 42] not %s", received))
+	}
+	if replies != string(numbers.BinaryHandshake) {
+		t.Fatal(fmt.Errorf("replies should be: %q not %q", numbers.BinaryHandshake, replies))
+	}
+}
+
+func TestBinaryTCPControllerTerminate(t *testing.T) {
+	wire := string(numbers.BinaryHandshake) + numbersFrame(123456789) + frame(numbers.FrameTerminate, nil)
+	numbersOut := make(chan *numbers.Batch, 1)
+	terminate := make(chan int)
+
+	err, _ := serveController(t, numbers.BinaryTCPController, wire, numbersOut, terminate)
+	if err != numbers.TERMINATED {
+		t.Fatal(fmt.Errorf("error should be: %v not %v", numbers.TERMINATED, err))
+	}
+	expectNumber(numbersOut, 123456789, t)
+	select {
+	case <-terminate:
+	default:
+		t.Fatal("terminate should be closed")
+	}
+}
+
+func TestBinaryTCPControllerRejects(t *testing.T) {
+	handshake := string(numbers.BinaryHandshake)
+	tests := []struct {
+		name string
+		wire string
+		err  error
+	}{
+		{"wrong magic", "NTXT\x01", numbers.ErrHandshake},
+		{"wrong version", "NBIN\x02", numbers.ErrHandshake},
+		{"unknown frame", handshake + frame(7, nil), numbers.ErrFrame},
+		{"partial number", handshake + frame(numbers.FrameNumbers, []byte{1, 2, 3}), numbers.ErrFrame},
+		{"too long frame", handshake + frame(numbers.FrameNumbers, make([]byte, numbers.MaxFrameLength+4)), numbers.ErrFrame},
+		{"too long terminate frame", handshake + frame(numbers.FrameTerminate, make([]byte, 257)), numbers.ErrFrame},
+		{"number too high", handshake + numbersFrame(1000000000), numbers.ErrNumberTooHigh},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err, _ := runController(t, numbers.BinaryTCPController, test.wire)
+			if !errors.Is(err, test.err) {
+				t.Fatal(fmt.Errorf("error should be: %v not %v", test.err, err))
+			}
+		})
+	}
+}
+
+func TestBinaryTCPControllerTerminateWithToken(t *testing.T) {
+	policy := numbers.InputPolicy{Terminate: numbers.TerminateWithToken, TerminateToken: "secret"}
+	controller := numbers.NewBinaryTCPController(policy)
+	handshake := string(numbers.BinaryHandshake)
+
+	_, err, _ := runController(t, controller, handshake+frame(numbers.FrameTerminate, []byte("guess")))
+	if !errors.Is(err, numbers.ErrTerminateRefused) {
+		t.Fatal(fmt.Errorf("error should be: %v not %v", numbers.ErrTerminateRefused, err))
+	}
+	_, err, _ = runController(t, controller, handshake+frame(numbers.FrameTerminate, []byte("secret")))
+	if err != numbers.TERMINATED {
+		t.Fatal(fmt.Errorf("error should be: %v not %v", numbers.TERMINATED, err))
+	}
+}
+
+func BenchmarkBinaryTCPController(b *testing.B) {
+	b.ReportAllocs()
+	numbersIn := make(chan *numbers.Batch)
+	go func() {
+		for batch := range numbersIn {
+			batch.Release()
+		}
+	}()
+	defer close(numbersIn)
+	batch := make([]int, 256)
+	for i := range batch {
+		batch[i] = 123456789
+	}
+	conn := &framesConn{handshake: numbers.BinaryHandshake, frame: []byte(numbersFrame(batch...)), frames: b.N/256 + 1}
+
+	b.ResetTimer()
+	if err := numbers.BinaryTCPController(context.Background(), conn, numbersIn, make(chan int)); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// framesConn is a connection reading the handshake and then the same frame a number of times.
+type framesConn struct {
+	linesConn
+	handshake []byte
+	frame     []byte
+	frames    int
+}
+
+func (f *framesConn) Read(b []byte) (int, error) {
+	if f.handshake != nil {
+		n := copy(b, f.handshake)
+		f.handshake = nil
+		f.linesConn = linesConn{line: f.frame, lines: f.frames}
+		return n, nil
+	}
+	return f.linesConn.Read(b)
+}
+
+func (f *framesConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (f *framesConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (f *framesConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+// numbersFrame returns a numbers frame of the binary protocol with the given numbers.
+func numbersFrame(numbers ...int) string {
+	payload := make([]byte, 4*len(numbers))
+	for i, number := range numbers {
+		binary.BigEndian.PutUint32(payload[4*i:], uint32(number))
+	}
+	return frame(1, payload)
+}
+
+func frame(frameType byte, payload []byte) string {
+	header := make([]byte, 5)
+	header[0] = frameType
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	return string(header) + string(payload)
+}
@@ -0,0 +1,68 @@
+package numbers
+
+import (
+	"fmt"
+	"io"
+)
+
+const wordSize = 64
+
+// BitSet is a dense set of the numbers in [0, size) backed by a bit array.
+// It is allocated once, so test and set are O(1) and never allocate.
+type BitSet struct {
+	words []uint64
+	len   int
+}
+
+// NewBitSet creates a BitSet able to hold the numbers in [0, size).
+func NewBitSet(size int) *BitSet {
+	return &BitSet{words: make([]uint64, (size+wordSize-1)/wordSize)}
+}
+
+// TestAndSet adds the number to the set and returns if it was already there.
+// The number must be in [0, size).
+func (b *BitSet) TestAndSet(number int) bool {
+	word := &b.words[number/wordSize]
+	mask := uint64(1) << (uint(number) % wordSize)
+	if *word&mask != 0 {
+		return true
+	}
+	*word |= mask
+	b.len++
+	return false
+}
+
+// Contains returns if the number is in the set, the number must be in [0, size).
+func (b *BitSet) Contains(number int) bool {
+	return b.words[number/wordSize]&(uint64(1)<<(uint(number)%wordSize)) != 0
+}
+
+// Len returns how many numbers are in the set.
+func (b *BitSet) Len() int {
+	return b.len
+}
+
+// WriteTo writes the length of the set and its bit array.
+func (b *BitSet) WriteTo(w io.Writer) (int64, error) {
+	written, err := writeUint64s(w, uint64(b.len), uint64(len(b.words)))
+	if err != nil {
+		return written, err
+	}
+	n, err := writeWords(w, b.words)
+	return written + n, err
+}
+
+// ReadFrom reads the set written by WriteTo, it must have been written by a BitSet of the same size.
+func (b *BitSet) ReadFrom(r io.Reader) (int64, error) {
+	var length, words uint64
+	read, err := readUint64s(r, &length, &words)
+	if err != nil {
+		return read, err
+	}
+	if words != uint64(len(b.words)) {
+		return read, fmt.Errorf("bitset should have %d words, not %d", len(b.words), words)
+	}
+	n, err := readWords(r, b.words)
+	b.len = int(length)
+	return read + n, err
+}
@@ -0,0 +1,65 @@
+package numbers_test
+
+import (
+	"fmt"
+	"testing"
+	"tgracchus/numbers"
+)
+
+const maxNumbers = 1000000000
+
+// spread is coprime with maxNumbers, so i*spread%maxNumbers is unique for every i < maxNumbers.
+const spread = 387420489
+
+func TestBitSetTestAndSet(t *testing.T) {
+	set := numbers.NewBitSet(maxNumbers)
+	for _, number := range []int{0, 63, 64, 123456789, maxNumbers - 1} {
+		if set.TestAndSet(number) {
+			t.Fatal(fmt.Errorf("number %d should not be in the set", number))
+		}
+		if !set.TestAndSet(number) {
+			t.Fatal(fmt.Errorf("number %d should be in the set", number))
+		}
+	}
+	if set.Len() != 5 {
+		t.Fatal(fmt.Errorf("len should be: %d not %d", 5, set.Len()))
+	}
+}
+
+var uniquesBenchmarks = []struct {
+	name    string
+	uniques int
+}{
+	{"2M", 2000000},
+	{"50M", 50000000},
+	{"500M", 500000000},
+}
+
+func BenchmarkBitSetUniques(b *testing.B) {
+	for _, bench := range uniquesBenchmarks {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				set := numbers.NewBitSet(maxNumbers)
+				for n := 0; n < bench.uniques; n++ {
+					set.TestAndSet(n * spread % maxNumbers)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMapUniques(b *testing.B) {
+	for _, bench := range uniquesBenchmarks {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				set := make(map[int]bool)
+				for n := 0; n < bench.uniques; n++ {
+					number := n * spread % maxNumbers
+					if _, ok := set[number]; !ok {
+						set[number] = true
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package numbers
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// BloomFilter is a probabilistic Deduplicator with a fixed memory footprint.
+// It never misses a number already added, but a new number is wrongly reported as
+// already added with the configured false positive rate once it holds its capacity.
+type BloomFilter struct {
+	bits   []uint64
+	size   uint64
+	hashes int
+	len    int
+}
+
+// NewBloomFilter creates a BloomFilter sized to hold capacity numbers with the given false positive rate.
+func NewBloomFilter(capacity int, falsePositiveRate float64) (*BloomFilter, error) {
+	if capacity <= 0 {
+		return nil, fmt.Errorf("bloom filter capacity should be more than 0, not %d", capacity)
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, fmt.Errorf("bloom filter false positive rate should be between 0 and 1, not %f", falsePositiveRate)
+	}
+	size := math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := int(math.Max(1, math.Round(size/float64(capacity)*math.Ln2)))
+	words := (uint64(size) + wordSize - 1) / wordSize
+	return &BloomFilter{bits: make([]uint64, words), size: words * wordSize, hashes: hashes}, nil
+}
+
+// TestAndSet adds the number to the filter and returns if it was, probably, already there.
+func (b *BloomFilter) TestAndSet(number int) bool {
+	h1 := mix64(uint64(number))
+	h2 := mix64(h1) | 1
+	present := true
+	for i := 0; i < b.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % b.size
+		word := &b.bits[bit/wordSize]
+		mask := uint64(1) << (bit % wordSize)
+		if *word&mask == 0 {
+			present = false
+			*word |= mask
+		}
+	}
+	if !present {
+		b.len++
+	}
+	return present
+}
+
+// Contains returns if the number was, probably, added.
+func (b *BloomFilter) Contains(number int) bool {
+	h1 := mix64(uint64(number))
+	h2 := mix64(h1) | 1
+	for i := 0; i < b.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % b.size
+		if b.bits[bit/wordSize]&(uint64(1)<<(bit%wordSize)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns how many numbers have been added as new, false positives are not counted.
+func (b *BloomFilter) Len() int {
+	return b.len
+}
+
+// mix64 is the splitmix64 finalizer, it spreads close numbers all over the 64 bits.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// WriteTo writes the length and the configuration of the filter followed by its bit array.
+func (b *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	written, err := writeUint64s(w, uint64(b.len), b.size, uint64(b.hashes))
+	if err != nil {
+		return written, err
+	}
+	n, err := writeWords(w, b.bits)
+	return written + n, err
+}
+
+// ReadFrom reads the filter written by WriteTo, it must have been written by a BloomFilter with the same configuration.
+func (b *BloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	var length, size, hashes uint64
+	read, err := readUint64s(r, &length, &size, &hashes)
+	if err != nil {
+		return read, err
+	}
+	if size != b.size || hashes != uint64(b.hashes) {
+		return read, fmt.Errorf("bloom filter should have %d bits and %d hashes, not %d bits and %d hashes",
+			b.size, b.hashes, size, hashes)
+	}
+	n, err := readWords(r, b.bits)
+	b.len = int(length)
+	return read + n, err
+}
@@ -1,19 +1,54 @@
 package main
 
 import (
+	"fmt"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"log"
 	"os"
 	"runtime/pprof"
+	"strconv"
 	"strings"
 	"tgracchus/numbers"
+	"time"
 )
 
 func main() {
 	pflag.Bool("profile", false, "profile the server")
+	pflag.String("config", "", "config file with the flags as keys, --concurrent-connections is reloaded from it on SIGHUP")
 	pflag.Int("concurrent-connections", 5, "number of concurrent connections")
-	pflag.String("port", "4000", "tcp port where to start the server")
+	pflag.String("over-capacity", "queue", "what to do with a connection over --concurrent-connections: queue or reject with ERR busy")
+	pflag.Duration("queue-timeout", 10*time.Second, "time a queued connection waits to be served before it is rejected")
+	pflag.Int("queue-length", 1024, "connections waiting to be served at most, the ones over it are rejected")
+	pflag.String("port", "4000", "tcp port where to start the server at localhost, when there is no --listen")
+	pflag.StringSlice("listen", nil, "address where to start the server, repeatable: host:port, [::]:port or unix:/path.sock")
+	pflag.String("socket-mode", "", "file mode of the unix sockets in octal, like 0660, the one of the umask when empty")
+	pflag.String("socket-owner", "", "owner of the unix sockets: user, user:group or :group, unchanged when empty")
+	pflag.String("binary-port", "", "tcp port where to serve the binary protocol, not served when empty")
+	pflag.String("query-port", "", "tcp port where to serve the membership queries, not served when empty")
+	pflag.Bool("query-first-seen", false, "keep when every number is first seen to reply it to the queries, it takes memory per number")
+	pflag.String("dedup", numbers.BitSetDeduplicator, "deduplicator backend: map, bitset, roaring or bloom")
+	pflag.Int("bloom-capacity", 100000000, "numbers the bloom deduplicator is sized for")
+	pflag.Float64("bloom-false-positive-rate", 0.001, "false positive rate of the bloom deduplicator at its capacity")
+	pflag.Int("shards", 0, "number of NumberStore shards, every one deduplicating in its own goroutine, 0 for one per CPU")
+	pflag.String("invalid-input", "disconnect", "what to do with an invalid line: disconnect, skip or reply with an error")
+	pflag.Int("max-invalid", 0, "disconnect a client after this many invalid lines within --invalid-window, 0 for no limit")
+	pflag.Duration("invalid-window", time.Minute, "time window for --max-invalid, 0 for the whole connection")
+	pflag.Duration("grace-period", 10*time.Second, "time to drain the connections on SIGINT or SIGTERM before terminating")
+	pflag.String("admin-address", "", "address of the admin channel, host:port or a unix socket path, not served when empty")
+	pflag.String("admin-network", "tcp", "network of the admin channel: tcp or unix")
+	pflag.String("admin-token", "", "token of the admin channel and of --data-terminate token, better set with ADMIN_TOKEN")
+	pflag.String("data-terminate", "allowed", "terminate lines on the data ports: allowed, disabled or token")
+	pflag.String("http-addr", "", "address of the http stats and health endpoints, host:port, not served when empty")
+	pflag.Bool("ack", false, "reply every line with NEW, DUP or ERR <reason> once it is deduplicated")
+	pflag.Bool("resume", false, "load the existing numbers.log and append to it instead of truncating it")
+	pflag.String("snapshot-path", "numbers.snapshot", "file where the snapshots are written and restored from when resuming")
+	pflag.Duration("snapshot-period", 0, "time between snapshots, 0 disables them")
+	pflag.Duration("report-period", 10*time.Second, "time between reports")
+	pflag.Duration("history-retention", 24*time.Hour, "how long the report windows are kept for /history, 0 disables it")
+	pflag.Bool("report-log", true, "log every report")
+	pflag.String("report-json", "", "file where every report is written as a line of JSON, not written when empty")
+	pflag.String("report-csv", "", "file where every report is written as a CSV record, not written when empty")
 	viper.AutomaticEnv()
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	err := viper.BindPFlags(pflag.CommandLine)
@@ -21,9 +56,23 @@ func main() {
 		log.Fatal(err)
 	}
 	pflag.Parse()
+	if config := viper.GetString("config"); config != "" {
+		viper.SetConfigFile(config)
+		if err := viper.ReadInConfig(); err != nil {
+			log.Fatal(err)
+		}
+	}
 	connections := viper.GetInt("concurrent-connections")
-	port := viper.GetString("port")
+	listen := viper.GetStringSlice("listen")
+	if len(listen) == 0 {
+		listen = []string{"localhost:" + viper.GetString("port")}
+	}
 	profile := viper.GetBool("profile")
+	dedup, err := numbers.NewDeduplicatorFactory(viper.GetString("dedup"),
+		viper.GetInt("bloom-capacity"), viper.GetFloat64("bloom-false-positive-rate"))
+	if err != nil {
+		log.Fatal(err)
+	}
 	log.Printf("profile: %t", profile)
 	if profile {
 		f, err := os.Create("numbers_cpu.prof")
@@ -31,8 +80,145 @@ func main() {
 			log.Fatal(err)
 		}
 		pprof.StartCPUProfile(f)
-		defer pprof.StopCPUProfile()
 	}
 
-	numbers.StartNumberServer(connections, "localhost:"+port)
+	onInvalid, err := numbers.ParseInvalidInputPolicy(viper.GetString("invalid-input"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	overCapacity, err := numbers.ParseCapacityPolicy(viper.GetString("over-capacity"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	capacity := numbers.Capacity{Policy: overCapacity, QueueTimeout: viper.GetDuration("queue-timeout"),
+		QueueLength: viper.GetInt("queue-length")}
+
+	unixSocket := numbers.UnixSocket{Owner: viper.GetString("socket-owner")}
+	if socketMode := viper.GetString("socket-mode"); socketMode != "" {
+		mode, err := strconv.ParseUint(socketMode, 8, 32)
+		if err != nil {
+			log.Fatal(fmt.Errorf("--socket-mode should be an octal mode like 0660, not %s", socketMode))
+		}
+		unixSocket.Mode = os.FileMode(mode)
+	}
+
+	dataTerminate, err := numbers.ParseTerminatePolicy(viper.GetString("data-terminate"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	adminToken := viper.GetString("admin-token")
+	if dataTerminate == numbers.TerminateWithToken && adminToken == "" {
+		log.Fatal("--data-terminate token requires --admin-token")
+	}
+
+	queryAddress := ""
+	if queryPort := viper.GetString("query-port"); queryPort != "" {
+		queryAddress = "localhost:" + queryPort
+	}
+	binaryAddress := ""
+	if binaryPort := viper.GetString("binary-port"); binaryPort != "" {
+		binaryAddress = "localhost:" + binaryPort
+	}
+
+	var reporters []numbers.Reporter
+	if viper.GetBool("report-log") {
+		reporters = append(reporters, numbers.NewLogReporter())
+	}
+	var reportFiles []*os.File
+	for _, format := range []string{"report-json", "report-csv"} {
+		path := viper.GetString(format)
+		if path == "" {
+			continue
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reportFiles = append(reportFiles, f)
+		if format == "report-json" {
+			reporters = append(reporters, numbers.NewJSONReporter(f))
+		} else {
+			reporters = append(reporters, numbers.NewCSVReporter(f))
+		}
+	}
+	if len(reporters) == 0 {
+		log.Fatal("at least one of --report-log, --report-json or --report-csv is required")
+	}
+
+	var history *numbers.History
+	if retention := viper.GetDuration("history-retention"); retention > 0 {
+		history = numbers.NewHistory(retention, viper.GetDuration("report-period"))
+	}
+
+	var reload func() (numbers.Options, error)
+	if viper.GetString("config") != "" {
+		reload = func() (numbers.Options, error) {
+			if err := viper.ReadInConfig(); err != nil {
+				return numbers.Options{}, err
+			}
+			return numbers.Options{ConcurrentConnections: viper.GetInt("concurrent-connections")}, nil
+		}
+	}
+
+	err = numbers.StartNumberServer(numbers.Options{
+		ConcurrentConnections: connections,
+		Capacity:              capacity,
+		Listen:                listen,
+		UnixSocket:            unixSocket,
+		BinaryAddress:         binaryAddress,
+		Dedup:                 dedup,
+		Shards:                viper.GetInt("shards"),
+		Resume:                viper.GetBool("resume"),
+		SnapshotPath:          viper.GetString("snapshot-path"),
+		SnapshotPeriod:        viper.GetDuration("snapshot-period"),
+		GracePeriod:           viper.GetDuration("grace-period"),
+		HTTPAddress:           viper.GetString("http-addr"),
+		AdminNetwork:          viper.GetString("admin-network"),
+		AdminAddress:          viper.GetString("admin-address"),
+		AdminToken:            adminToken,
+		Ack:                   viper.GetBool("ack"),
+		ReportPeriod:          viper.GetDuration("report-period"),
+		Reporters:             reporters,
+		History:               history,
+		QueryAddress:          queryAddress,
+		QueryFirstSeen:        viper.GetBool("query-first-seen"),
+		Reload:                reload,
+		InputPolicy: numbers.InputPolicy{
+			OnInvalid:      onInvalid,
+			MaxInvalid:     viper.GetInt("max-invalid"),
+			InvalidWindow:  viper.GetDuration("invalid-window"),
+			Terminate:      dataTerminate,
+			TerminateToken: adminToken,
+		},
+	})
+	if profile {
+		pprof.StopCPUProfile()
+	}
+	for _, f := range reportFiles {
+		if closeErr := f.Close(); closeErr != nil {
+			log.Printf("%v", closeErr)
+		}
+	}
+	if err != nil {
+		log.Printf("%v", err)
+		os.Exit(exitStatus(err))
+	}
+}
+
+// Exit statuses of the server, 0 when it terminated with its connections drained.
+const (
+	// exitError is the status of a server that failed to start or to flush and close numbers.log.
+	exitError = 1
+	// exitNotDrained is the status of a server terminated before its connections were drained, by the grace
+	// period expiring or a second signal.
+	exitNotDrained = 2
+)
+
+// exitStatus returns the exit status for the error of the server.
+func exitStatus(err error) int {
+	if err == numbers.ErrGracePeriodExpired || err == numbers.ErrShutdownCanceled {
+		return exitNotDrained
+	}
+	return exitError
 }
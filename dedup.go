@@ -0,0 +1,132 @@
+package numbers
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// Deduplicator keeps track of the numbers already seen by the NumberStore.
+type Deduplicator interface {
+	// TestAndSet adds the number and returns if it was already there.
+	TestAndSet(number int) bool
+	// Contains returns if the number has been added, without adding it.
+	Contains(number int) bool
+	// Len returns how many different numbers have been added.
+	Len() int
+	// WriteTo writes the numbers in a binary format, used for snapshots.
+	io.WriterTo
+	// ReadFrom loads into an empty Deduplicator the numbers written by WriteTo.
+	io.ReaderFrom
+}
+
+// Names of the Deduplicator implementations available through NewDeduplicator.
+const (
+	MapDeduplicator     = "map"
+	BitSetDeduplicator  = "bitset"
+	RoaringDeduplicator = "roaring"
+	BloomDeduplicator   = "bloom"
+)
+
+// DeduplicatorFactory creates a Deduplicator for the numbers in [0, size).
+type DeduplicatorFactory func(size int) Deduplicator
+
+// NewDeduplicator creates the Deduplicator implementation with the given name for the whole number space.
+// bloomCapacity and bloomFalsePositiveRate are only used by the bloom one.
+func NewDeduplicator(name string, bloomCapacity int, bloomFalsePositiveRate float64) (Deduplicator, error) {
+	factory, err := NewDeduplicatorFactory(name, bloomCapacity, bloomFalsePositiveRate)
+	if err != nil {
+		return nil, err
+	}
+	return factory(maxNumbers), nil
+}
+
+// NewDeduplicatorFactory returns the factory of the Deduplicator implementation with the given name.
+// bloomCapacity is for the whole number space, every bloom filter is sized for its share of it.
+func NewDeduplicatorFactory(name string, bloomCapacity int, bloomFalsePositiveRate float64) (DeduplicatorFactory, error) {
+	switch name {
+	case MapDeduplicator:
+		return func(size int) Deduplicator { return NewMapSet() }, nil
+	case BitSetDeduplicator:
+		return func(size int) Deduplicator { return NewBitSet(size) }, nil
+	case RoaringDeduplicator:
+		return func(size int) Deduplicator { return NewRoaringBitmap() }, nil
+	case BloomDeduplicator:
+		if _, err := NewBloomFilter(1, bloomFalsePositiveRate); err != nil {
+			return nil, err
+		}
+		if bloomCapacity <= 0 {
+			return nil, fmt.Errorf("bloom filter capacity should be more than 0, not %d", bloomCapacity)
+		}
+		return func(size int) Deduplicator {
+			capacity := int(math.Ceil(float64(bloomCapacity) * float64(size) / maxNumbers))
+			filter, _ := NewBloomFilter(capacity, bloomFalsePositiveRate)
+			return filter
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown deduplicator %s, should be one of %s, %s, %s or %s",
+			name, MapDeduplicator, BitSetDeduplicator, RoaringDeduplicator, BloomDeduplicator)
+	}
+}
+
+// MapSet is an exact Deduplicator backed by a map, it uses memory proportional to the unique numbers.
+type MapSet map[int]bool
+
+// NewMapSet creates an empty MapSet.
+func NewMapSet() MapSet {
+	return make(MapSet)
+}
+
+// TestAndSet adds the number to the set and returns if it was already there.
+func (m MapSet) TestAndSet(number int) bool {
+	if _, ok := m[number]; ok {
+		return true
+	}
+	m[number] = true
+	return false
+}
+
+// Contains returns if the number is in the set.
+func (m MapSet) Contains(number int) bool {
+	return m[number]
+}
+
+// Len returns how many numbers are in the set.
+func (m MapSet) Len() int {
+	return len(m)
+}
+
+// WriteTo writes how many numbers are in the set followed by the sorted numbers.
+func (m MapSet) WriteTo(w io.Writer) (int64, error) {
+	sorted := make([]int, 0, len(m))
+	for number := range m {
+		sorted = append(sorted, number)
+	}
+	sort.Ints(sorted)
+	words := make([]uint64, len(sorted)+1)
+	words[0] = uint64(len(sorted))
+	for i, number := range sorted {
+		words[i+1] = uint64(number)
+	}
+	return writeWords(w, words)
+}
+
+// ReadFrom adds the numbers written by WriteTo to the set.
+func (m MapSet) ReadFrom(r io.Reader) (int64, error) {
+	var length uint64
+	read, err := readUint64s(r, &length)
+	if err != nil {
+		return read, err
+	}
+	for ; length > 0; length-- {
+		var number uint64
+		n, err := readUint64s(r, &number)
+		read += n
+		if err != nil {
+			return read, err
+		}
+		m[int(number)] = true
+	}
+	return read, nil
+}
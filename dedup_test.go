@@ -0,0 +1,93 @@
+package numbers_test
+
+import (
+	"fmt"
+	"testing"
+	"tgracchus/numbers"
+)
+
+func TestDeduplicators(t *testing.T) {
+	for _, name := range []string{numbers.MapDeduplicator, numbers.BitSetDeduplicator,
+		numbers.RoaringDeduplicator, numbers.BloomDeduplicator} {
+		t.Run(name, func(t *testing.T) {
+			dedup, err := numbers.NewDeduplicator(name, 100000, 0.0001)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for n := 0; n < 10000; n++ {
+				if dedup.Contains(n * spread % maxNumbers) {
+					t.Fatal(fmt.Errorf("number %d should not be contained in %s", n*spread%maxNumbers, name))
+				}
+				if dedup.TestAndSet(n * spread % maxNumbers) {
+					t.Fatal(fmt.Errorf("number %d should not be in %s", n*spread%maxNumbers, name))
+				}
+				if !dedup.Contains(n * spread % maxNumbers) {
+					t.Fatal(fmt.Errorf("number %d should be contained in %s", n*spread%maxNumbers, name))
+				}
+			}
+			for n := 0; n < 10000; n++ {
+				if !dedup.TestAndSet(n * spread % maxNumbers) {
+					t.Fatal(fmt.Errorf("number %d should be in %s", n*spread%maxNumbers, name))
+				}
+			}
+			if dedup.Len() != 10000 {
+				t.Fatal(fmt.Errorf("len should be: %d not %d", 10000, dedup.Len()))
+			}
+		})
+	}
+}
+
+func TestNewDeduplicatorUnknown(t *testing.T) {
+	_, err := numbers.NewDeduplicator("unknown", 0, 0)
+	if err == nil {
+		t.Fatal("unknown deduplicator error was expected")
+	}
+}
+
+func TestRoaringBitmapDenseContainer(t *testing.T) {
+	set := numbers.NewRoaringBitmap()
+	for n := 0; n < 1<<16; n += 2 {
+		if set.TestAndSet(n) {
+			t.Fatal(fmt.Errorf("number %d should not be in the set", n))
+		}
+	}
+	for n := 0; n < 1<<16; n++ {
+		if set.TestAndSet(n) != (n%2 == 0) {
+			t.Fatal(fmt.Errorf("number %d membership is wrong", n))
+		}
+	}
+	if set.Len() != 1<<16 {
+		t.Fatal(fmt.Errorf("len should be: %d not %d", 1<<16, set.Len()))
+	}
+}
+
+func TestBloomFilterFalsePositiveRate(t *testing.T) {
+	capacity := 100000
+	rate := 0.01
+	filter, err := numbers.NewBloomFilter(capacity, rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < capacity; n++ {
+		filter.TestAndSet(n)
+	}
+	falsePositives := 0
+	samples := 10000
+	for n := capacity; n < capacity+samples; n++ {
+		if filter.TestAndSet(n) {
+			falsePositives++
+		}
+	}
+	if measured := float64(falsePositives) / float64(samples); measured > 2*rate {
+		t.Fatal(fmt.Errorf("false positive rate should be around %f not %f", rate, measured))
+	}
+}
+
+func TestNewBloomFilterWrongConfig(t *testing.T) {
+	if _, err := numbers.NewBloomFilter(0, 0.01); err == nil {
+		t.Fatal("capacity error was expected")
+	}
+	if _, err := numbers.NewBloomFilter(10, 1); err == nil {
+		t.Fatal("false positive rate error was expected")
+	}
+}
@@ -0,0 +1,101 @@
+package numbers
+
+import (
+	"sync"
+	"time"
+)
+
+// Window is a report window kept by a History, with its rates.
+type Window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Unique and Duplicates are the numbers received in the window.
+	Unique     int64 `json:"unique"`
+	Duplicates int64 `json:"duplicates"`
+	// UniqueTotal and Total are the numbers received since the server first started, at the end of the window.
+	UniqueTotal int64 `json:"unique_total"`
+	Total       int64 `json:"total"`
+	// UniquePerSecond and DuplicatesPerSecond are the throughput of the window.
+	UniquePerSecond     float64 `json:"unique_per_second"`
+	DuplicatesPerSecond float64 `json:"duplicates_per_second"`
+	// DuplicateRatio is the ratio of the numbers received in the window that were duplicates, 0 when none was.
+	DuplicateRatio float64 `json:"duplicate_ratio"`
+}
+
+func newWindow(report Report) Window {
+	window := Window{
+		Start:       report.Time.Add(-report.Window),
+		End:         report.Time,
+		Unique:      report.WindowUnique,
+		Duplicates:  report.WindowDuplicates,
+		UniqueTotal: report.UniqueTotal,
+		Total:       report.Total,
+	}
+	if seconds := report.Window.Seconds(); seconds > 0 {
+		window.UniquePerSecond = float64(window.Unique) / seconds
+		window.DuplicatesPerSecond = float64(window.Duplicates) / seconds
+	}
+	if received := window.Unique + window.Duplicates; received > 0 {
+		window.DuplicateRatio = float64(window.Duplicates) / float64(received)
+	}
+	return window
+}
+
+// History is a Reporter keeping the report windows of the last retention in a ring buffer,
+// so they can be queried. It is safe to query it while it is reported to.
+type History struct {
+	retention time.Duration
+	mux       sync.Mutex
+	// windows is the ring buffer, the oldest window is at start.
+	windows []Window
+	start   int
+	count   int
+}
+
+// NewHistory returns a History keeping the windows that ended within retention of the last one.
+// It is sized for a report every reportPeriod, the oldest windows are dropped sooner when there are more reports.
+func NewHistory(retention time.Duration, reportPeriod time.Duration) *History {
+	if reportPeriod <= 0 {
+		reportPeriod = defaultReportPeriod
+	}
+	size := int(retention/reportPeriod) + 1
+	return &History{retention: retention, windows: make([]Window, size)}
+}
+
+// Report adds the window of the report, dropping the windows out of the retention.
+func (h *History) Report(report Report) error {
+	window := newWindow(report)
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for h.count > 0 && window.End.Sub(h.windows[h.start].End) > h.retention {
+		h.drop()
+	}
+	if h.count == len(h.windows) {
+		h.drop()
+	}
+	h.windows[(h.start+h.count)%len(h.windows)] = window
+	h.count++
+	return nil
+}
+
+// drop drops the oldest window.
+func (h *History) drop() {
+	h.windows[h.start] = Window{}
+	h.start = (h.start + 1) % len(h.windows)
+	h.count--
+}
+
+// Windows returns the windows overlapping the time range from to, oldest first.
+// A zero from or to leaves the range open on that side.
+func (h *History) Windows(from time.Time, to time.Time) []Window {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	windows := make([]Window, 0, h.count)
+	for i := 0; i < h.count; i++ {
+		window := h.windows[(h.start+i)%len(h.windows)]
+		if (from.IsZero() || window.End.After(from)) && (to.IsZero() || window.Start.Before(to)) {
+			windows = append(windows, window)
+		}
+	}
+	return windows
+}
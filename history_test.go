@@ -0,0 +1,61 @@
+package numbers_test
+
+import (
+	"fmt"
+	"testing"
+	"tgracchus/numbers"
+	"time"
+)
+
+var historyStart = time.Date(2020, 1, 2, 3, 0, 0, 0, time.UTC)
+
+// reportWindows reports a window of 10s, ending every 10s after historyStart, for every pair of unique and duplicates.
+func reportWindows(t *testing.T, history *numbers.History, counts ...int64) {
+	for i := 0; i < len(counts); i += 2 {
+		err := history.Report(numbers.Report{
+			Time:             historyStart.Add(time.Duration(i/2+1) * 10 * time.Second),
+			WindowUnique:     counts[i],
+			WindowDuplicates: counts[i+1],
+			Window:           10 * time.Second,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHistoryWindowsRates(t *testing.T) {
+	history := numbers.NewHistory(time.Minute, 10*time.Second)
+	reportWindows(t, history, 30, 10, 0, 0)
+	windows := history.Windows(time.Time{}, time.Time{})
+	expected := []numbers.Window{
+		{Start: historyStart, End: historyStart.Add(10 * time.Second), Unique: 30, Duplicates: 10,
+			UniquePerSecond: 3, DuplicatesPerSecond: 1, DuplicateRatio: 0.25},
+		{Start: historyStart.Add(10 * time.Second), End: historyStart.Add(20 * time.Second)},
+	}
+	if fmt.Sprint(windows) != fmt.Sprint(expected) {
+		t.Fatal(fmt.Errorf("windows should be: %+v not %+v", expected, windows))
+	}
+}
+
+func TestHistoryKeepsRetention(t *testing.T) {
+	history := numbers.NewHistory(20*time.Second, 10*time.Second)
+	reportWindows(t, history, 1, 0, 2, 0, 3, 0, 4, 0, 5, 0)
+	windows := history.Windows(time.Time{}, time.Time{})
+	if len(windows) != 3 || windows[0].Unique != 3 || windows[2].Unique != 5 {
+		t.Fatal(fmt.Errorf("windows should be the last 3, not %+v", windows))
+	}
+}
+
+func TestHistoryWindowsInRange(t *testing.T) {
+	history := numbers.NewHistory(time.Hour, 10*time.Second)
+	reportWindows(t, history, 1, 0, 2, 0, 3, 0, 4, 0)
+	windows := history.Windows(historyStart.Add(15*time.Second), historyStart.Add(25*time.Second))
+	if len(windows) != 2 || windows[0].Unique != 2 || windows[1].Unique != 3 {
+		t.Fatal(fmt.Errorf("windows should be the 2nd and the 3rd, not %+v", windows))
+	}
+	windows = history.Windows(historyStart.Add(30*time.Second), time.Time{})
+	if len(windows) != 1 || windows[0].Unique != 4 {
+		t.Fatal(fmt.Errorf("windows should be the 4th, not %+v", windows))
+	}
+}
@@ -0,0 +1,89 @@
+package numbers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// startHTTP serves the stats and health endpoints of the server at address until stop is closed:
+// /stats the Stats as JSON, /metrics the metrics in the Prometheus text format,
+// /healthz if the server is alive and /readyz if it is accepting connections.
+// With a history, /history serves its windows as JSON, in the range of the from and to RFC 3339 parameters, if any.
+func startHTTP(address string, status *status, history *History, stop chan int, logger *log.Logger) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.Wrap(err, "http listener")
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status.stats()); err != nil {
+			logger.Printf("%v", errors.Wrap(err, "write stats"))
+		}
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := writeMetrics(w, status); err != nil {
+			logger.Printf("%v", errors.Wrap(err, "write metrics"))
+		}
+	})
+	if history != nil {
+		mux.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+			from, err := timeParameter(r, "from")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			to, err := timeParameter(r, "to")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(history.Windows(from, to)); err != nil {
+				logger.Printf("%v", errors.Wrap(err, "write history"))
+			}
+		})
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !status.ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write([]byte(status.state.Load().(string) + "\n"))
+	})
+	server := &http.Server{Handler: mux, ErrorLog: logger}
+	logger.Printf("http server started at:%s", address)
+	go func() {
+		if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+			logger.Printf("%v", errors.Wrap(err, "http server"))
+		}
+	}()
+	go func() {
+		<-stop
+		if err := server.Close(); err != nil {
+			logger.Printf("%v", errors.Wrap(err, "closing http server"))
+		}
+	}()
+	return nil
+}
+
+// timeParameter returns the RFC 3339 time of the query parameter, the zero time when it is not set.
+func timeParameter(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("wrong %s parameter: %q, it should be an RFC 3339 time", name, value)
+	}
+	return t, nil
+}
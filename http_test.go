@@ -0,0 +1,204 @@
+package numbers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"tgracchus/numbers"
+	"time"
+)
+
+func TestHTTPEndpoints(t *testing.T) {
+	stopped, conn := startTestServer(t, numbers.Options{
+		Address:     "localhost:4106",
+		HTTPAddress: "localhost:4107",
+		InputPolicy: numbers.InputPolicy{OnInvalid: numbers.Skip},
+	})
+	defer conn.Close()
+	if _, err := conn.Write([]byte("000000001\n000000002\n000000001\n12345678a\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	var stats numbers.Stats
+	for start := time.Now(); stats.Total < 3; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal(fmt.Errorf("total should be: 3 not %d", stats.Total))
+		}
+		stats = getStats(t, "http://localhost:4107/stats")
+	}
+	expected := numbers.Stats{
+		State:             numbers.StateServing,
+		Total:             3,
+		Unique:            2,
+		WindowUnique:      2,
+		WindowDuplicates:  1,
+		ActiveConnections: 1,
+		ConnectionLimit:   2,
+		Rejected:          numbers.RejectedStats{NonDigit: 1},
+		UptimeSeconds:     stats.UptimeSeconds,
+	}
+	if stats != expected {
+		t.Fatal(fmt.Errorf("stats should be: %+v not %+v", expected, stats))
+	}
+	for _, endpoint := range []string{"/healthz", "/readyz"} {
+		response, err := http.Get("http://localhost:4107" + endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Fatal(fmt.Errorf("%s status should be: %d not %d", endpoint, http.StatusOK, response.StatusCode))
+		}
+	}
+
+	if _, err := conn.Write([]byte("terminate\n")); err != nil {
+		t.Fatal(err)
+	}
+	<-stopped
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	stopped, conn := startTestServer(t, numbers.Options{
+		Address:      "localhost:4108",
+		HTTPAddress:  "localhost:4109",
+		AdminNetwork: "tcp",
+		AdminAddress: "localhost:4120",
+		AdminToken:   "secret",
+		InputPolicy:  numbers.InputPolicy{OnInvalid: numbers.Skip},
+	})
+	defer conn.Close()
+	if _, err := conn.Write([]byte("000000001\n000000002\n000000001\n12345678a\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"numbers_received_total 3\n",
+		"numbers_unique_total 2\n",
+		"numbers_duplicate_total 1\n",
+		`numbers_rejected_lines_total{reason="non_digit"} 1` + "\n",
+		`numbers_rejected_lines_total{reason="sign"} 0` + "\n",
+		"numbers_connections_accepted_total 1\n",
+		"numbers_connections_closed_total 0\n",
+		"numbers_connections_active 1\n",
+		`numbers_backlog{stage="shards"} 0` + "\n",
+		`numbers_backlog{stage="file_writer"} `,
+		"# TYPE numbers_file_writer_flush_seconds histogram\n",
+		`numbers_file_writer_flush_seconds_bucket{le="+Inf"} `,
+		"numbers_file_writer_flush_seconds_count ",
+	}
+	var metrics string
+	for start := time.Now(); !strings.Contains(metrics, expected[0]); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal(fmt.Errorf("metrics should have: %q not %s", expected[0], metrics))
+		}
+		metrics = getMetrics(t, "http://localhost:4109/metrics")
+	}
+	for _, line := range expected {
+		if !strings.Contains(metrics, line) {
+			t.Fatal(fmt.Errorf("metrics should have: %q not %s", line, metrics))
+		}
+	}
+
+	admin := dialAdmin(t, "tcp", "localhost:4120")
+	defer admin.Close()
+	expectAdminReply(t, admin, "AUTH secret", "OK")
+	expectAdminReply(t, admin, numbers.AdminReset, "OK")
+	if stats := getStats(t, "http://localhost:4109/stats"); stats.Rejected.NonDigit != 0 {
+		t.Fatal(fmt.Errorf("rejected lines in the stats should be 0 after reset, not %d", stats.Rejected.NonDigit))
+	}
+	if metrics := getMetrics(t, "http://localhost:4109/metrics"); !strings.Contains(metrics, expected[3]) {
+		t.Fatal(fmt.Errorf("metrics should still have after reset: %q not %s", expected[3], metrics))
+	}
+
+	if _, err := conn.Write([]byte("terminate\n")); err != nil {
+		t.Fatal(err)
+	}
+	<-stopped
+}
+
+func TestHistoryEndpoint(t *testing.T) {
+	stopped, conn := startTestServer(t, numbers.Options{
+		Address:      "localhost:4111",
+		HTTPAddress:  "localhost:4112",
+		ReportPeriod: 20 * time.Millisecond,
+		History:      numbers.NewHistory(time.Hour, 20*time.Millisecond),
+	})
+	defer conn.Close()
+	if _, err := conn.Write([]byte("000000001\n000000002\n000000001\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	var windows []numbers.Window
+	for start := time.Now(); len(windows) == 0 || windows[len(windows)-1].Total < 3; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal(fmt.Errorf("history should have the 3 numbers, not %+v", windows))
+		}
+		windows = getHistory(t, "http://localhost:4112/history?from="+start.Add(-time.Second).Format(time.RFC3339))
+	}
+	var unique, duplicates int64
+	for _, window := range windows {
+		unique += window.Unique
+		duplicates += window.Duplicates
+		if window.Duplicates > 0 && window.DuplicateRatio <= 0 {
+			t.Fatal(fmt.Errorf("window should have a duplicate ratio: %+v", window))
+		}
+	}
+	if unique != 2 || duplicates != 1 {
+		t.Fatal(fmt.Errorf("windows should add up to 2 unique and 1 duplicate, not %+v", windows))
+	}
+	response, err := http.Get("http://localhost:4112/history?to=yesterday")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatal(fmt.Errorf("status should be: %d not %d", http.StatusBadRequest, response.StatusCode))
+	}
+
+	if _, err := conn.Write([]byte("terminate\n")); err != nil {
+		t.Fatal(err)
+	}
+	<-stopped
+}
+
+func getHistory(t *testing.T, url string) []numbers.Window {
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var windows []numbers.Window
+	if err := json.NewDecoder(response.Body).Decode(&windows); err != nil {
+		t.Fatal(err)
+	}
+	return windows
+}
+
+func getMetrics(t *testing.T, url string) string {
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func getStats(t *testing.T, url string) numbers.Stats {
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var stats numbers.Stats
+	if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
@@ -0,0 +1,278 @@
+package numbers
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// unixPrefix is the prefix of the addresses of unix sockets, followed by the path of the socket.
+const unixPrefix = "unix:"
+
+// splitAddress returns the network and the address of an address: unix:/path.sock is a unix socket,
+// anything else a tcp host:port.
+func splitAddress(address string) (string, string) {
+	if strings.HasPrefix(address, unixPrefix) {
+		return "unix", strings.TrimPrefix(address, unixPrefix)
+	}
+	return "tcp", address
+}
+
+// UnixSocket configures the unix sockets the server listens at.
+type UnixSocket struct {
+	// Mode is the file mode of the socket, the one of the umask when 0.
+	Mode os.FileMode
+	// Owner is the owner of the socket as user, user:group or :group, names or ids. It is not changed when empty.
+	Owner string
+}
+
+// listen listens for connections at address, a tcp port 0 is an ephemeral one. A unix socket is listened at as
+// listenUnix does.
+func listen(ctx context.Context, address string, socket UnixSocket) (net.Listener, error) {
+	network, address := splitAddress(address)
+	if network == "unix" {
+		return listenUnix(ctx, address, socket)
+	}
+	conf := &net.ListenConfig{KeepAlive: 15}
+	l, err := conf.Listen(ctx, network, address)
+	if err != nil {
+		return nil, errors.Wrap(err, "Star listener")
+	}
+	return l, nil
+}
+
+// listenUnix listens at the unix socket at path, removing first one left by a server that is not running.
+// The socket is created in a private directory and only moved to path once it has the mode and the owner
+// socket says, so no one can connect to it before. It is removed once closed.
+func listenUnix(ctx context.Context, path string, socket UnixSocket) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".numbers")
+	if err != nil {
+		return nil, errors.Wrap(err, "unix socket directory")
+	}
+	defer os.RemoveAll(dir)
+	private := filepath.Join(dir, filepath.Base(path))
+	conf := &net.ListenConfig{}
+	l, err := conf.Listen(ctx, "unix", private)
+	if err != nil {
+		return nil, errors.Wrap(err, "Star listener")
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	err = socket.apply(private)
+	if err == nil {
+		err = errors.Wrap(os.Rename(private, path), "move unix socket")
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &unixListener{Listener: l, addr: &net.UnixAddr{Name: path, Net: "unix"}}, nil
+}
+
+// unixListener is a unix socket moved to addr after listening, it is removed from there once closed.
+type unixListener struct {
+	net.Listener
+	addr *net.UnixAddr
+}
+
+func (l *unixListener) Close() error {
+	err := l.Listener.Close()
+	if removeErr := os.Remove(l.addr.Name); removeErr != nil && !os.IsNotExist(removeErr) && err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return l.addr
+}
+
+// apply sets the mode and the owner of the socket at path.
+func (s UnixSocket) apply(path string) error {
+	if s.Mode != 0 {
+		if err := os.Chmod(path, s.Mode); err != nil {
+			return errors.Wrap(err, "unix socket mode")
+		}
+	}
+	if s.Owner == "" {
+		return nil
+	}
+	uid, gid, err := lookupOwner(s.Owner)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(os.Chown(path, uid, gid), "unix socket owner")
+}
+
+// lookupOwner returns the user and group ids of an owner as user, user:group or :group, -1 for the ones not given.
+func lookupOwner(owner string) (int, int, error) {
+	uid, gid := -1, -1
+	name, group := owner, ""
+	if i := strings.Index(owner, ":"); i >= 0 {
+		name, group = owner[:i], owner[i+1:]
+	}
+	if name != "" {
+		id := name
+		if u, err := user.Lookup(name); err == nil {
+			id = u.Uid
+		}
+		var err error
+		if uid, err = strconv.Atoi(id); err != nil {
+			return 0, 0, fmt.Errorf("unknown user %s", name)
+		}
+	}
+	if group != "" {
+		id := group
+		if g, err := user.LookupGroup(group); err == nil {
+			id = g.Gid
+		}
+		var err error
+		if gid, err = strconv.Atoi(id); err != nil {
+			return 0, 0, fmt.Errorf("unknown group %s", group)
+		}
+	}
+	return uid, gid, nil
+}
+
+// removeStaleSocket removes the unix socket at path left by a previous server, so it can be listened at again.
+// A socket still accepting connections, or any other file, is left alone and is an error.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "stat unix socket")
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a unix socket", path)
+	}
+	if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		c.Close()
+		return fmt.Errorf("%s is in use by another server", path)
+	}
+	return errors.Wrap(os.Remove(path), "remove stale unix socket")
+}
+
+// listenAll listens at every one of the addresses, once all of them are listening, and returns them as one listener.
+func listenAll(ctx context.Context, addresses []string, socket UnixSocket, logger *log.Logger) (*multiListener, error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("no address to listen at")
+	}
+	listeners := make([]net.Listener, 0, len(addresses))
+	for _, address := range addresses {
+		l, err := listen(ctx, address, socket)
+		if err != nil {
+			for _, l := range listeners {
+				closeListener(l, logger)
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return newMultiListener(listeners, logger), nil
+}
+
+// accepted is a connection accepted by one of the listeners of a multiListener, or the error accepting it.
+type accepted struct {
+	c   net.Conn
+	err error
+}
+
+// multiListener accepts the connections of several listeners as a single one. Closing it closes all of them.
+// Temporary errors accepting a connection are retried, the first other one, but closing it, is returned by Accept.
+type multiListener struct {
+	listeners []net.Listener
+	accepted  chan accepted
+	closed    chan int
+	closing   sync.Once
+	logger    *log.Logger
+}
+
+func newMultiListener(listeners []net.Listener, logger *log.Logger) *multiListener {
+	m := &multiListener{listeners: listeners, accepted: make(chan accepted), closed: make(chan int), logger: logger}
+	for _, l := range listeners {
+		go m.accept(l)
+	}
+	return m
+}
+
+// accept accepts the connections of l until the multiListener is closed or l fails with an error that is not
+// temporary.
+func (m *multiListener) accept(l net.Listener) {
+	var delay time.Duration
+	for {
+		c, err := l.Accept()
+		if err != nil && isTemporary(err) {
+			delay = acceptBackoff(delay)
+			m.logger.Printf("%v, retrying in %v", errors.Wrap(err, "accept connection at "+l.Addr().String()), delay)
+			select {
+			case <-time.After(delay):
+				continue
+			case <-m.closed:
+				return
+			}
+		}
+		delay = 0
+		select {
+		case m.accepted <- accepted{c: c, err: err}:
+			if err != nil {
+				return
+			}
+		case <-m.closed:
+			if c != nil {
+				c.Close()
+			}
+			return
+		}
+	}
+}
+
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case accepted := <-m.accepted:
+		return accepted.c, accepted.err
+	case <-m.closed:
+		return nil, errors.New("use of closed multi listener")
+	}
+}
+
+// Close closes all the listeners and returns the first error closing them.
+func (m *multiListener) Close() error {
+	var err error
+	m.closing.Do(func() {
+		close(m.closed)
+		for _, l := range m.listeners {
+			if closeErr := l.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
+
+// Addr returns the address of the first listener.
+func (m *multiListener) Addr() net.Addr {
+	return m.listeners[0].Addr()
+}
+
+// addrs returns the addresses of all the listeners.
+func (m *multiListener) addrs() []net.Addr {
+	addrs := make([]net.Addr, len(m.listeners))
+	for i, l := range m.listeners {
+		addrs[i] = l.Addr()
+	}
+	return addrs
+}
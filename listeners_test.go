@@ -0,0 +1,198 @@
+package numbers_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"tgracchus/numbers"
+	"time"
+)
+
+func TestServerListensAtEveryAddress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "numbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	listen := []string{"127.0.0.1:0", "unix:" + filepath.Join(dir, "numbers.sock")}
+	if l, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		l.Close()
+		listen = append(listen, "[::1]:0")
+	}
+	server, outputPath := newTestServer(t, numbers.Options{Listen: listen, Ack: true})
+	if err := server.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	addrs := server.Addrs()
+	if len(addrs) != len(listen)+1 {
+		t.Fatal(fmt.Errorf("server should listen at %d addresses, not %v", len(listen)+1, addrs))
+	}
+	for i, addr := range addrs {
+		conn, err := net.Dial(addr.Network(), addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectAck(t, conn, fmt.Sprintf("%09d", i+1))
+		conn.Close()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logged := strings.Fields(string(content)); len(logged) != len(addrs) {
+		t.Fatal(fmt.Errorf("number log should hold %d numbers, not %q", len(addrs), content))
+	}
+}
+
+func TestStartServersClosesEveryListener(t *testing.T) {
+	addresses := []string{"localhost:4118", "localhost:4119"}
+	accepted := make(chan net.Conn, len(addresses))
+	cnnListener := func(ctx context.Context, l net.Listener) {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}
+	stop := make(chan int)
+	returned := make(chan error, 1)
+	go func() {
+		returned <- numbers.StartServers(context.Background(), cnnListener, addresses, numbers.UnixSocket{}, stop)
+	}()
+	for _, address := range addresses {
+		var conn net.Conn
+		var err error
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+			if conn, err = net.Dial("tcp", address); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		select {
+		case c := <-accepted:
+			c.Close()
+		case <-time.After(5 * time.Second):
+			t.Fatal(fmt.Errorf("timeout while waiting for a connection at %s", address))
+		}
+	}
+	close(stop)
+	if err := <-returned; err != nil {
+		t.Fatal(err)
+	}
+	for _, address := range addresses {
+		if conn, err := net.Dial("tcp", address); err == nil {
+			conn.Close()
+			t.Fatal(fmt.Errorf("%s should be closed", address))
+		}
+	}
+}
+
+func TestStartServersServesUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "numbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "numbers.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cnnListener, out := numbers.NewSingleConnectionListener(numbers.DefaultTCPController, make(chan int))
+	stop := make(chan int)
+	returned := make(chan error, 1)
+	go func() {
+		returned <- numbers.StartServers(ctx, cnnListener, []string{"unix:" + path}, numbers.UnixSocket{Mode: 0600}, stop)
+	}()
+	var client net.Conn
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if client, err = net.Dial("unix", path); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModePerm != 0600 {
+		t.Fatal(fmt.Errorf("socket mode should be %v, not %v", os.FileMode(0600), info.Mode()&os.ModePerm))
+	}
+	sendData(t, client, "098765432")
+	expectNumber(out, 98765432, t)
+	close(stop)
+	if err := <-returned; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal(fmt.Errorf("socket should be removed on shutdown, stat: %v", err))
+	}
+}
+
+func TestStartServersRefusesUnixSocketInUse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "numbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "numbers.sock")
+	live, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+	cnnListener := func(ctx context.Context, l net.Listener) {}
+	err = numbers.StartServers(context.Background(), cnnListener, []string{"unix:" + path}, numbers.UnixSocket{},
+		make(chan int))
+	if err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Fatal(fmt.Errorf("a socket in use should not be replaced, error: %v", err))
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartServersFailsOnUnknownSocketOwner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "numbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "numbers.sock")
+	cnnListener := func(ctx context.Context, l net.Listener) {}
+	err = numbers.StartServers(context.Background(), cnnListener, []string{"unix:" + path},
+		numbers.UnixSocket{Owner: "no-such-user-of-numbers"}, make(chan int))
+	if err == nil {
+		t.Fatal("start should fail with an unknown socket owner")
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatal(fmt.Errorf("no socket should be left, not %d files", len(files)))
+	}
+}
@@ -1,7 +1,10 @@
 package load_test
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"net"
@@ -68,6 +71,79 @@ func TestServe_50connections_100clients_10000reqs(t *testing.T) {
 	testServer(10, 10000, "localhost:4000")
 }
 
+func TestBinaryServer_10clients_1000000reqs(t *testing.T) {
+	testBinaryServer(10, 1000000, "localhost:4001")
+}
+
+func TestBinaryServer_50clients_10000reqs(t *testing.T) {
+	testBinaryServer(50, 10000, "localhost:4001")
+}
+
+func TestTextAndBinaryServer_5clients_100000reqs(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(10)
+	clients(&wg, 5, 100000, "localhost:4000")
+	binaryClients(&wg, 5, 100000, "localhost:4001")
+	wg.Wait()
+}
+
+func testBinaryServer(clientsNumber int, reqs int, address string) {
+	var wg sync.WaitGroup
+	wg.Add(clientsNumber)
+	binaryClients(&wg, clientsNumber, reqs, address)
+	wg.Wait()
+}
+
+func binaryClients(wg *sync.WaitGroup, totalClients int, reqs int, address string) {
+	var barrier sync.WaitGroup
+	barrier.Add(1)
+	for clientNumber := 0; clientNumber < totalClients; clientNumber++ {
+		go binaryClient(wg, &barrier, clientNumber, reqs, address)
+	}
+	barrier.Done()
+}
+
+// binaryFrameNumbers is how many numbers a binary client sends per frame.
+const binaryFrameNumbers = 1024
+
+func binaryClient(wg *sync.WaitGroup, barrier *sync.WaitGroup, clientNumber int, reqs int, address string) {
+	defer wg.Done()
+	barrier.Wait()
+	dialer := net.Dialer{KeepAlive: 15}
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		log.Printf("Client %d connection error: %s", clientNumber, err)
+		return
+	}
+	defer conn.Close()
+	handshake := []byte{'N', 'B', 'I', 'N', 1}
+	if _, err := conn.Write(handshake); err != nil {
+		log.Printf("Client %d with error: %s", clientNumber, err)
+		return
+	}
+	reply := make([]byte, len(handshake))
+	if _, err := io.ReadFull(conn, reply); err != nil || !bytes.Equal(reply, handshake) {
+		log.Printf("Client %d handshake refused: %q, %v", clientNumber, reply, err)
+		return
+	}
+	frame := make([]byte, 5+4*binaryFrameNumbers)
+	frame[0] = 1
+	for sent := 0; sent < reqs; sent += binaryFrameNumbers {
+		count := reqs - sent
+		if count > binaryFrameNumbers {
+			count = binaryFrameNumbers
+		}
+		binary.BigEndian.PutUint32(frame[1:], uint32(4*count))
+		for i := 0; i < count; i++ {
+			binary.BigEndian.PutUint32(frame[5+4*i:], uint32(rand.Intn(1000000000)))
+		}
+		if _, err := conn.Write(frame[:5+4*count]); err != nil {
+			log.Printf("Client %d with error: %s", clientNumber, err)
+			return
+		}
+	}
+}
+
 func clients(wg *sync.WaitGroup, totalClients int, reqs int, address string) {
 	var barrier sync.WaitGroup
 	barrier.Add(1)
@@ -105,7 +181,6 @@ func client(wg *sync.WaitGroup, barrier *sync.WaitGroup, clientNumber int, reqs
 			return
 		}
 	}
-	wg.Done()
 }
 
 func send(conn net.Conn, msg string) error {
@@ -0,0 +1,130 @@
+package numbers
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// flushBuckets are the upper bounds, in seconds, of the FileWriter flush latency histogram buckets.
+var flushBuckets = []float64{0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1}
+
+// histogram is a Prometheus histogram updated without locking.
+type histogram struct {
+	bounds []float64
+	// counts are the observations of every bucket, not cumulative, the last one is the +Inf bucket.
+	counts []int64
+	sum    int64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := 0
+	for i < len(h.bounds) && seconds > h.bounds[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// writerMetrics are the metrics of a FileWriter.
+type writerMetrics struct {
+	// written are the numbers written to the number log since the server started.
+	written int64
+	flushes *histogram
+}
+
+func newWriterMetrics() *writerMetrics {
+	return &writerMetrics{flushes: newHistogram(flushBuckets)}
+}
+
+// timedWriter measures how long every write to w takes, a write of the FileWriter buffer is a flush.
+type timedWriter struct {
+	w       io.Writer
+	metrics *writerMetrics
+}
+
+func (t *timedWriter) Write(b []byte) (int, error) {
+	start := time.Now()
+	n, err := t.w.Write(b)
+	t.metrics.flushes.observe(time.Since(start))
+	return n, err
+}
+
+// writeMetrics writes the metrics of the server in the Prometheus text exposition format.
+func writeMetrics(w io.Writer, status *status) error {
+	m := &metricsWriter{w: w}
+	if counters, ok := status.counters.Load().(*storeCounters); ok {
+		received, unique := counters.sinceStart()
+		m.metric("numbers_received_total", "counter", "Numbers received since the server started.", received)
+		m.metric("numbers_unique_total", "counter", "Unique numbers received since the server started.", unique)
+		m.metric("numbers_duplicate_total", "counter", "Duplicated numbers received since the server started.",
+			received-unique)
+		m.metric("numbers_backlog", "gauge", "Numbers in flight waiting for a pipeline stage.")
+		m.sample("numbers_backlog", `stage="shards"`, atomic.LoadInt64(&counters.routed)-received)
+		m.sample("numbers_backlog", `stage="file_writer"`, unique-atomic.LoadInt64(&status.writer.written))
+	}
+	if status.rejections != nil {
+		m.metric("numbers_rejected_lines_total", "counter", "Lines rejected by reason since the server started.")
+		rejected := status.rejections.totals()
+		for i, reason := range []string{"wrong_length", "non_digit", "sign", "missing_new_line"} {
+			m.sample("numbers_rejected_lines_total", `reason="`+reason+`"`, rejected[i])
+		}
+	}
+	accepted, closed := atomic.LoadInt64(&status.accepted), atomic.LoadInt64(&status.closed)
+	m.metric("numbers_connections_accepted_total", "counter", "Connections accepted.", accepted)
+	m.metric("numbers_connections_closed_total", "counter", "Connections closed.", closed)
+	m.metric("numbers_connections_active", "gauge", "Connections open.", accepted-closed)
+	m.metric("numbers_connections_limit", "gauge", "Connections served at the same time.",
+		atomic.LoadInt64(&status.limit))
+	m.metric("numbers_connections_queued_total", "counter", "Connections over capacity queued for a worker.",
+		atomic.LoadInt64(&status.queued))
+	m.metric("numbers_connections_rejected_total", "counter", "Connections over capacity rejected as busy.",
+		atomic.LoadInt64(&status.rejected))
+	m.histogram("numbers_file_writer_flush_seconds", "Latency of the FileWriter flushes to numbers.log.",
+		status.writer.flushes)
+	return m.err
+}
+
+// metricsWriter writes metrics in the Prometheus text exposition format, keeping the first error.
+type metricsWriter struct {
+	w   io.Writer
+	err error
+}
+
+// metric writes the help and type of a metric and its value, if it has a single one without labels.
+func (m *metricsWriter) metric(name string, metricType string, help string, value ...int64) {
+	m.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+	if len(value) == 1 {
+		m.printf("%s %d\n", name, value[0])
+	}
+}
+
+func (m *metricsWriter) sample(name string, labels string, value int64) {
+	m.printf("%s{%s} %d\n", name, labels, value)
+}
+
+func (m *metricsWriter) histogram(name string, help string, h *histogram) {
+	m.metric(name, "histogram", help)
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += atomic.LoadInt64(&h.counts[i])
+		m.printf("%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	cumulative += atomic.LoadInt64(&h.counts[len(h.bounds)])
+	m.printf("%s_bucket{le=\"+Inf\"} %d\n", name, cumulative)
+	m.printf("%s_sum %s\n", name, strconv.FormatFloat(time.Duration(atomic.LoadInt64(&h.sum)).Seconds(), 'g', -1, 64))
+	m.printf("%s_count %d\n", name, cumulative)
+}
+
+func (m *metricsWriter) printf(format string, a ...interface{}) {
+	if m.err == nil {
+		_, m.err = fmt.Fprintf(m.w, format, a...)
+	}
+}
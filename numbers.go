@@ -3,110 +3,222 @@ package numbers
 import (
 	"bufio"
 	"context"
-	"fmt"
 	"github.com/pkg/errors"
 	"io"
 	"log"
 	"net"
 	"os"
-	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const reportPeriod = 10
+// flushPeriod is the time between flushes of the number log.
+const flushPeriod = 10 * time.Second
 const numberLogFileName = "numbers.log"
 
-// StartNumberServer start the number server tcp application with
-// number of concurrent server connections and at the given address.
-func StartNumberServer(concurrentConnections int, address string) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	if concurrentConnections < 0 {
-		log.Panicf("concurrency level should be more than 0, not %d", concurrentConnections)
-	}
-	terminate := make(chan int)
-	listeners := make([]ConnectionListener, concurrentConnections)
-	numbersOuts := make([]chan int, concurrentConnections)
-	for i := 0; i < concurrentConnections; i++ {
-		cnnListener, numbers := NewSingleConnectionListener(DefaultTCPController, terminate)
-		listeners[i] = cnnListener
-		numbersOuts[i] = numbers
-	}
-	deDuplicatedNumbers := NumberStore(reportPeriod, numbersOuts, terminate)
-	dir, err := os.Getwd()
-	if err != nil {
-		log.Fatal(err)
-	}
-	done := FileWriter(deDuplicatedNumbers, dir+"/"+numberLogFileName)
-	multipleListener := NewMultipleConnectionListener(listeners)
+// defaultConcurrentConnections is how many connections are served at the same time when none is configured.
+const defaultConcurrentConnections = 5
 
-	cancelContextWhenTerminateSignal(cancel, terminate, done)
-	err = StartServer(ctx, multipleListener, address, done)
-	if err != nil {
-		log.Printf("%v", err)
-	}
+// maxNumbers is the size of the number space, every 9 digit number is below it.
+const maxNumbers = 1000000000
+
+// Options configures a Server.
+type Options struct {
+	// ConcurrentConnections is how many connections are served at the same time, 5 when 0.
+	ConcurrentConnections int
+	// Capacity is what happens to the connections over ConcurrentConnections, queued for up to 10s by default.
+	Capacity Capacity
+	// Address where the server listens, host:port, a 0 port listens at an ephemeral one.
+	Address string
+	// Listen are more addresses where the server listens, all of them feeding the same NumberStore:
+	// host:port, [::]:port or unix:/path.sock.
+	Listen []string
+	// UnixSocket configures the unix sockets of Address, Listen and BinaryAddress.
+	UnixSocket UnixSocket
+	// OutputPath is the number log the unique numbers are written to, numbers.log in the working directory when empty.
+	OutputPath string
+	// Controller serves the connections at Address instead of the text protocol controller, when set.
+	Controller TCPControllerV2
+	// Dedup creates the Deduplicators keeping track of the numbers already seen, one per shard, BitSets when nil.
+	Dedup DeduplicatorFactory
+	// Shards is how many shards the number space is split into, every one deduplicated in its own goroutine.
+	// It is one per CPU when 0.
+	Shards int
+	// Resume loads the numbers of an existing number log and appends to it instead of truncating it.
+	Resume bool
+	// SnapshotPath is where the snapshots of Dedup are written and, when resuming, restored from.
+	SnapshotPath string
+	// SnapshotPeriod is the time between snapshots, no snapshots are taken when it is 0.
+	SnapshotPeriod time.Duration
+	// InputPolicy is how the controllers handle invalid input.
+	InputPolicy InputPolicy
+	// BinaryAddress is where the binary protocol is served, next to the text one, it is not served when empty.
+	BinaryAddress string
+	// GracePeriod is how long the connections are drained for after a SIGINT or a SIGTERM,
+	// before the server is terminated.
+	GracePeriod time.Duration
+	// AdminNetwork is the network of the admin channel, tcp or unix.
+	AdminNetwork string
+	// AdminAddress is where the admin channel is served, it is not served when empty.
+	AdminAddress string
+	// AdminToken is the token the admin clients authenticate with, it is required to serve the admin channel.
+	AdminToken string
+	// HTTPAddress is where the stats and health endpoints are served, they are not served when empty.
+	HTTPAddress string
+	// Ack makes the controllers reply every line with NEW, DUP or ERR once the NumberStore has answered it.
+	Ack bool
+	// ReportPeriod is the time between reports, 10s when 0.
+	ReportPeriod time.Duration
+	// Reporters receive every report, a LogReporter when empty.
+	Reporters []Reporter
+	// History, if any, keeps the report windows, it is also served over HTTP.
+	History *History
+	// QueryAddress is where the membership queries are served, they are not served when empty.
+	QueryAddress string
+	// QueryFirstSeen keeps when every number is first seen, to reply it to the membership queries.
+	QueryFirstSeen bool
+	// Logger receives the logs of the server, the standard logger when nil.
+	Logger *log.Logger
+	// Reload, if any, returns the options on a SIGHUP to StartNumberServer, only their ConcurrentConnections
+	// are applied.
+	Reload func() (Options, error)
 }
 
-func cancelContextWhenTerminateSignal(cancel context.CancelFunc,
-	terminate chan int, done chan int) chan int {
-	go func() {
-		for {
-			select {
-			case <-terminate:
-				cancel()
-			case <-done:
-				return
+// restore loads the snapshot at snapshotPath, if there is a usable one, and then replays the number log
+// after it into numbers. Returns the total numbers received so far.
+func restore(filePath string, snapshotPath string, numbers Deduplicator, logger *log.Logger) (int64, error) {
+	var offset, total int64
+	if snapshotPath != "" {
+		snapshot, err := ReadSnapshotHeader(snapshotPath, numbers)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				logger.Printf("%v", errors.Wrap(err, "snapshot not usable, replaying the whole number log"))
+			}
+		} else if info, err := os.Stat(filePath); err != nil || info.Size() < snapshot.LogOffset {
+			logger.Printf("snapshot %s is ahead of %s, replaying the whole number log", snapshotPath, filePath)
+		} else {
+			snapshot, err = ReadSnapshot(snapshotPath, numbers)
+			if err != nil {
+				return 0, errors.Wrap(err, "restore snapshot")
 			}
+			offset = snapshot.LogOffset
+			total = snapshot.Total
+			logger.Printf("restored %d numbers from %s", snapshot.Unique, snapshotPath)
 		}
-	}()
-	return terminate
+	}
+	resumed, err := resumeNumberLog(filePath, offset, numbers, logger)
+	if err != nil {
+		return 0, err
+	}
+	logger.Printf("resumed %d numbers from %s", resumed, filePath)
+	return total + int64(resumed), nil
 }
 
 const readDeadline = 30 * time.Second
 
 // DefaultTCPController handles the parsing protocol defined in the requirements.
 // Accepts a channel terminate to send termination signal and return a channel from where the numbers will be issued
-// once they are parsed.
-func DefaultTCPController(ctx context.Context, c net.Conn, numbers chan int, terminate chan int) error {
+// once they are parsed, grouped in batches. A batch is issued when full, after batchLatency or as soon as there
+// is nothing else to parse, so numbers do not wait for the client.
+// A line that is not exactly 9 digits, or terminate, followed by a new line is rejected with a LineError.
+func DefaultTCPController(ctx context.Context, c net.Conn, numbers chan *Batch, terminate chan int) error {
+	return v1(DefaultTCPControllerV2)(ctx, c, numbers, terminate)
+}
+
+// DefaultTCPControllerV2 works as DefaultTCPController until ctx is canceled.
+func DefaultTCPControllerV2(ctx context.Context, c net.Conn, numbers chan *Batch) error {
+	return readNumbers(ctx, c, numbers, InputPolicy{}, nil)
+}
+
+// NewTCPController returns a TCPController working as DefaultTCPController that handles the rejected lines
+// as the policy says and counts them in rejections.
+func NewTCPController(policy InputPolicy, rejections *Rejections) TCPController {
+	return v1(NewTCPControllerV2(policy, rejections))
+}
+
+// NewTCPControllerV2 works as NewTCPController, returning a TCPControllerV2.
+func NewTCPControllerV2(policy InputPolicy, rejections *Rejections) TCPControllerV2 {
+	rejections.addPolicy(policy)
+	return func(ctx context.Context, c net.Conn, numbers chan *Batch) error {
+		return readNumbers(ctx, c, numbers, policy, rejections)
+	}
+}
+
+func readNumbers(ctx context.Context, c net.Conn, numbers chan *Batch, policy InputPolicy, rejections *Rejections) error {
 	reader := bufio.NewReader(c)
+	batches := newBatcher(numbers, ctx.Done())
+	defer batches.release()
+	invalid := newInvalidLines(policy)
 	for {
-		err := c.SetReadDeadline(time.Now().Add(readDeadline))
-		if err != nil {
-			return errors.Wrap(err, "SetReadDeadline")
+		if reader.Buffered() < lineLength {
+			if err := batches.flush(); err != nil {
+				return err
+			}
 		}
-		data, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				return nil
+		number, terminateLine, token, err := nextLine(c, reader, policy)
+		if _, rejected := err.(*LineError); rejected {
+			if err := policy.reject(c, err, invalid, rejections); err != nil {
+				return batches.stop(err)
 			}
-			return errors.Wrap(err, "ReadString")
+			continue
 		}
-
-		data = strings.TrimSuffix(data, "\n")
-		if len(data) != 9 {
-			return errors.Wrap(fmt.Errorf("client: %s, no 9 char length string %s", c.RemoteAddr().String(), data), "check for 9 digits")
+		if err == io.EOF {
+			return batches.stop(nil)
 		}
-		if data == "terminate" {
-			select {
-			case <-terminate:
-				return TERMINATED
-			default:
-				close(terminate)
+		if err != nil {
+			return batches.stop(err)
+		}
+		if terminateLine {
+			if err := policy.terminate(c, token); err != nil {
+				return batches.stop(err)
+			}
+			if err := batches.flush(); err != nil {
+				return err
 			}
 			return TERMINATED
 		}
-		number, err := strconv.Atoi(data)
-		if err != nil {
-			return errors.Wrap(err, "strconv.Atoi")
+
+		if err := batches.add(number); err != nil {
+			return err
 		}
+	}
+}
 
-		select {
-		case <-terminate:
-			return TERMINATED
-		default:
-			numbers <- number
+// nextLine reads and parses the next line of the client, straight from the read buffer, as parseLine does
+// with the terminate tokens of the policy. Returns a LineError when the line is rejected and io.EOF once the
+// client is done.
+func nextLine(c net.Conn, reader *bufio.Reader, policy InputPolicy) (int, bool, []byte, error) {
+	if err := c.SetReadDeadline(time.Now().Add(readDeadline)); err != nil {
+		return 0, false, nil, errors.Wrap(err, "SetReadDeadline")
+	}
+	line, err := reader.ReadSlice('\n')
+	switch {
+	case err == bufio.ErrBufferFull:
+		length, err := discardLine(reader, len(line))
+		if err != nil && err != io.EOF {
+			return 0, false, nil, errors.Wrap(err, "ReadSlice")
+		}
+		return 0, false, nil, &LineError{Reason: ErrWrongLength, Length: length}
+	case err == io.EOF && len(line) == 0:
+		return 0, false, nil, io.EOF
+	case err != nil && err != io.EOF:
+		return 0, false, nil, errors.Wrap(err, "ReadSlice")
+	}
+	return parseLine(line, policy.Terminate == TerminateWithToken)
+}
+
+// discardLine reads the rest of a line longer than the read buffer, read already bytes of it.
+// Returns the length of the whole line without its new line.
+func discardLine(reader *bufio.Reader, read int) (int, error) {
+	for {
+		line, err := reader.ReadSlice('\n')
+		read += len(line)
+		if err != bufio.ErrBufferFull {
+			if err == nil {
+				read--
+			}
+			return read, err
 		}
 	}
 }
@@ -114,112 +226,405 @@ func DefaultTCPController(ctx context.Context, c net.Conn, numbers chan int, ter
 // NumberStore given a list of channels it listens to all of them and deduplicated the numbers received.
 // If the number is not duplicated handles it to the returned channel for further processing.
 // It also keeps track of total and unique numbers and the current 10s windows of unique and duplicated numbers.
-func NumberStore(reportPeriod int, ins []chan int, terminate chan int) chan int {
-	out := make(chan int)
-	in := fanIn(ins, terminate)
-	numbers := make(map[int]bool)
-	var total int64 = 0
-	var currentUnique int64 = 0
-	var currentDuplicated int64 = 0
-	ticker := time.NewTicker(time.Duration(reportPeriod) * time.Second)
+// Numbers already seen are tracked by the given Deduplicator.
+// Closing terminate is the terminate cut-over, it is logged and the numbers still in flight in the ins channels
+// are stored until all of them are closed, then the returned channel is closed.
+func NumberStore(reportPeriod int, numbers Deduplicator, ins []chan *Batch, terminate chan int) chan *Batch {
+	return ShardedNumberStore(reportPeriod, NewShards(1, func(size int) Deduplicator { return numbers }), ins, terminate)
+}
+
+// ShardedNumberStore works as NumberStore but every shard deduplicates its range of numbers in its own goroutine.
+// Numbers are routed to their shard as soon as they are received and the statistics of all the shards
+// are merged for the reports.
+func ShardedNumberStore(reportPeriod int, shards *Shards, ins []chan *Batch, terminate chan int) chan *Batch {
+	return numberStore(time.Duration(reportPeriod)*time.Second, []Reporter{NewLogReporter()}, shards, ins, nil,
+		whenClosed(terminate), newStoreCounters(0, shards.Count()), nil, nil, nil, standardLogger)
+}
+
+// storeRequest asks a NumberStore to report right away or to reset its statistics, done is closed once it is done.
+type storeRequest struct {
+	reset bool
+	done  chan int
+}
+
+// numberStore works as ShardedNumberStore, closing terminated is the cut-over, updating the given counters
+// and sending the reports to the reporters. The channels received from joins, if any, join ins until it is closed.
+// The rejected lines, if any, are logged with the reports to logger. It also serves the requests, if any.
+func numberStore(reportPeriod time.Duration, reporters []Reporter, shards *Shards, ins []chan *Batch,
+	joins <-chan chan *Batch, terminated <-chan struct{}, counters *storeCounters, rejections *Rejections, snapshots *checkpoint,
+	requests chan storeRequest, logger *log.Logger) chan *Batch {
+	out := make(chan *Batch)
+	routed := route(ins, joins, shards, counters)
+	stats := counters.shards
+	shards.owners = make([]shardOwner, shards.Count())
+	var wg sync.WaitGroup
+	wg.Add(shards.Count())
+	for i := range routed {
+		owner := shardOwner{tasks: make(chan func()), done: make(chan int)}
+		shards.owners[i] = owner
+		atomic.StoreInt64(&stats[i].uniqueTotal, int64(shards.shards[i].Len()))
+		atomic.AddInt64(&counters.restoredUnique, int64(shards.shards[i].Len()))
+		go func(i int) {
+			defer wg.Done()
+			defer close(owner.done)
+			deduplicate(shards, i, routed[i], owner.tasks, &stats[i], out)
+		}(i)
+	}
+	done := make(chan int)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(reportPeriod)
 	go func() {
 		defer ticker.Stop()
 		defer close(out)
+		var reportedRejections [4]int64
+		windowStart := time.Now()
+		report := func(final bool, tick time.Time) {
+			current := Report{Time: tick, Window: tick.Sub(windowStart), Final: final}
+			current.WindowUnique, current.WindowDuplicates = counters.swapWindow()
+			current.Total, current.UniqueTotal = counters.totals()
+			if rejections != nil {
+				rejected := rejections.all()
+				current.WindowRejected = RejectedStats{
+					WrongLength:    rejected[0] - reportedRejections[0],
+					NonDigit:       rejected[1] - reportedRejections[1],
+					Sign:           rejected[2] - reportedRejections[2],
+					MissingNewLine: rejected[3] - reportedRejections[3],
+					Disconnected:   rejections.Disconnected(),
+				}
+				current.InputPolicy = rejections.policy()
+				reportedRejections = rejected
+			}
+			windowStart = tick
+			reportAll(reporters, current, logger)
+		}
+		cutOver := terminated
+		var snapshotTicks <-chan time.Time
+		if snapshots != nil {
+			snapshotTicker := time.NewTicker(snapshots.period)
+			defer snapshotTicker.Stop()
+			snapshotTicks = snapshotTicker.C
+		}
 		for {
 			select {
-			case number, more := <-in:
-				if more {
-					total++
-					if _, ok := numbers[number]; ok {
-						currentDuplicated++
-					} else {
-						currentUnique++
-						numbers[number] = true
-						out <- number
-					}
-				} else {
-					return
+			case <-done:
+				shards.owners = nil
+				if snapshots != nil {
+					snapshots.take(counters, shards)
 				}
+				report(true, time.Now())
+				return
+			case <-cutOver:
+				total, _ := counters.totals()
+				logger.Printf("Terminate cut-over at %v, %d numbers received so far, storing the ones in flight",
+					time.Now(), total)
+				cutOver = nil
+			case <-snapshotTicks:
+				snapshots.take(counters, shards)
 			case tick := <-ticker.C:
-				log.Printf("Report %v Received %d unique numbers, %d duplicates. Unique total: %d. Total: %d",
-					tick, currentUnique, currentDuplicated, len(numbers), total)
-				currentUnique = 0
-				currentDuplicated = 0
+				report(false, tick)
+			case request := <-requests:
+				if request.reset {
+					counters.swapWindow()
+					windowStart = time.Now()
+					rejections.reset()
+					reportedRejections = [4]int64{}
+					logger.Printf("statistics reset")
+				} else {
+					report(false, time.Now())
+				}
+				close(request.done)
 			}
 		}
 	}()
 	return out
 }
 
-func fanIn(ins []chan int, terminate chan int) chan int {
+// deduplicate is the loop of the shard, it handles to out the numbers of in not already in the shard
+// and runs the tasks it is given in between. Duplicates are removed from every batch in place.
+// The shard is locked while every batch is deduplicated, so it is queried in between batches.
+func deduplicate(shards *Shards, shard int, in chan *Batch, tasks chan func(), stats *shardStats, out chan *Batch) {
+	numbers, base, lock := shards.shards[shard], shard*shards.size, &shards.locks[shard]
+	var firstSeen map[int]int64
+	if shards.firstSeen != nil {
+		firstSeen = shards.firstSeen[shard]
+	}
+	for {
+		select {
+		case batch, more := <-in:
+			if !more {
+				return
+			}
+			received := len(batch.Numbers)
+			unique := batch.Numbers[:0]
+			lock.Lock()
+			for i, number := range batch.Numbers {
+				if !numbers.TestAndSet(number - base) {
+					unique = append(unique, number)
+					if batch.verdicts != nil {
+						batch.verdicts.unique[batch.position(i)] = true
+					}
+				}
+			}
+			if firstSeen != nil {
+				now := time.Now().UnixNano()
+				for _, number := range unique {
+					firstSeen[number] = now
+				}
+			}
+			lock.Unlock()
+			batch.Numbers = unique
+			if batch.verdicts != nil {
+				batch.verdicts.answered()
+				batch.verdicts = nil
+			}
+			atomic.AddInt64(&stats.total, int64(received))
+			atomic.AddInt64(&stats.currentDuplicated, int64(received-len(unique)))
+			atomic.AddInt64(&stats.currentUnique, int64(len(unique)))
+			atomic.AddInt64(&stats.uniqueTotal, int64(len(unique)))
+			if len(unique) > 0 {
+				out <- batch
+			} else {
+				batch.Release()
+			}
+		case task := <-tasks:
+			task()
+		}
+	}
+}
+
+// checkpoint configures the periodic snapshots of a NumberStore.
+type checkpoint struct {
+	path   string
+	period time.Duration
+	// syncs asks the FileWriter to flush and fsync the number log.
+	syncs  chan chan logSync
+	logger *log.Logger
+}
+
+// take writes a snapshot of the shards that never has numbers missing in the number log.
+// The log offset is taken before the shards are written, so every number before it is in the snapshot,
+// and the snapshot only replaces the previous one once the log holds every number written in it.
+// Its total counts the unique numbers before the log offset, the ones after it are counted once replayed.
+func (c *checkpoint) take(counters *storeCounters, shards *Shards) {
+	duplicates := counters.duplicates(shards)
+	synced := c.sync()
+	if synced.err != nil {
+		c.logger.Printf("%v", errors.Wrap(synced.err, "snapshot not taken"))
+		return
+	}
+	start := time.Now()
+	snapshot := Snapshot{LogOffset: synced.offset, Total: duplicates + synced.offset/lineLength}
+	_, snapshot.Unique = counters.totals()
+	err := writeSnapshot(c.path, snapshot, shards, func() error {
+		return c.sync().err
+	}, c.logger)
+	if err != nil {
+		c.logger.Printf("%v", errors.Wrap(err, "snapshot not taken"))
+		return
+	}
+	c.logger.Printf("snapshot of %d numbers at log offset %d taken in %v", snapshot.Unique, snapshot.LogOffset, time.Since(start))
+}
+
+func (c *checkpoint) sync() logSync {
+	reply := make(chan logSync)
+	c.syncs <- reply
+	return <-reply
+}
+
+// route fans in all the ins channels and routes every number to the channel of the shard owning it.
+// There is a goroutine per in channel, so routing is not a bottleneck. Every batch received is split
+// in a batch per shard. The channels received from joins, if any, are fanned in as the ins ones.
+// The shard channels are closed once joins and all the ins channels are closed and drained.
+// The numbers routed are counted in counters.
+func route(ins []chan *Batch, joins <-chan chan *Batch, shards *Shards, counters *storeCounters) []chan *Batch {
 	var wg sync.WaitGroup
-	wg.Add(len(ins))
-	out := make(chan int)
-	go func() {
-		for _, ch := range ins {
-			go func(in chan int) {
-				defer wg.Done()
-				for {
-					select {
-					case element, more := <-in:
-						if more {
-							out <- element
-						} else {
-							return
-						}
-					case <-terminate:
-						return
+	outs := make([]chan *Batch, shards.Count())
+	for i := range outs {
+		outs[i] = make(chan *Batch)
+	}
+	routeIn := func(in chan *Batch) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			routed := make([]*Batch, len(outs))
+			for batch := range in {
+				atomic.AddInt64(&counters.routed, int64(len(batch.Numbers)))
+				if len(outs) == 1 {
+					outs[0] <- batch
+					continue
+				}
+				parts := 0
+				for i, number := range batch.Numbers {
+					shard := shards.shard(number)
+					if routed[shard] == nil {
+						routed[shard] = NewBatch()
+						routed[shard].verdicts = batch.verdicts
+						parts++
+					}
+					routed[shard].Numbers = append(routed[shard].Numbers, number)
+					if batch.verdicts != nil {
+						routed[shard].positions = append(routed[shard].positions, i)
+					}
+				}
+				if batch.verdicts != nil {
+					batch.verdicts.split(parts)
+				}
+				batch.Release()
+				for shard, shardBatch := range routed {
+					if shardBatch != nil {
+						outs[shard] <- shardBatch
+						routed[shard] = nil
 					}
 				}
-			}(ch)
+			}
+		}()
+	}
+	go func() {
+		for _, in := range ins {
+			routeIn(in)
+		}
+		if joins != nil {
+			for in := range joins {
+				routeIn(in)
+			}
 		}
 		wg.Wait()
-		close(out)
+		for _, out := range outs {
+			close(out)
+		}
 	}()
-	return out
+	return outs
 }
 
 // FileWriter writes al the numbers received at in channel and writes them to filePath.
 // Returns a done channel when it is terminated
-func FileWriter(in chan int, filePath string) chan int {
-	done := make(chan int)
-	f, err := os.Create(filePath)
+func FileWriter(in chan *Batch, filePath string) chan int {
+	f, offset, err := openNumberLog(filePath, false, standardLogger)
 	if err != nil {
 		log.Fatal(err)
 	}
-	b := bufio.NewWriter(f)
-	ticker := time.NewTicker(time.Duration(reportPeriod) * time.Second)
+	return doneWhenClosed(fileWriter(in, f, offset, nil, newWriterMetrics(), standardLogger))
+}
+
+// AppendFileWriter works as FileWriter but appends the numbers to filePath instead of truncating it.
+// Returns an error if filePath cannot be opened.
+func AppendFileWriter(in chan *Batch, filePath string) (chan int, error) {
+	f, offset, err := openNumberLog(filePath, true, standardLogger)
+	if err != nil {
+		return nil, err
+	}
+	return doneWhenClosed(fileWriter(in, f, offset, nil, newWriterMetrics(), standardLogger)), nil
+}
+
+// openNumberLog creates the number log at filePath, or opens it to append to it, and returns its size.
+func openNumberLog(filePath string, appending bool, logger *log.Logger) (*os.File, int64, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appending {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(filePath, flags, 0666)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "open number log")
+	}
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		closeLog(f, logger)
+		return nil, 0, errors.Wrap(err, "seek number log")
+	}
+	return f, offset, nil
+}
+
+// doneWhenClosed returns a channel closed once the file writer has closed its file.
+func doneWhenClosed(closed chan error) chan int {
+	done := make(chan int)
+	go func() {
+		<-closed
+		close(done)
+	}()
+	return done
+}
+
+// logSync is the answer of a FileWriter to a sync request, the size of the number log once synced.
+type logSync struct {
+	offset int64
+	err    error
+}
+
+// fileWriter writes the numbers received at in to f, of size offset, it also flushes and fsyncs f for every sync request.
+// Once in is closed, f is flushed, fsynced and closed, and the error doing it, if any, is sent to the returned channel.
+// The numbers written and the latency of every flush to f are recorded in metrics.
+func fileWriter(in chan *Batch, f *os.File, offset int64, syncs chan chan logSync, metrics *writerMetrics,
+	logger *log.Logger) chan error {
+	closed := make(chan error, 1)
+	b := bufio.NewWriter(&timedWriter{w: f, metrics: metrics})
+	ticker := time.NewTicker(flushPeriod)
 	go func() {
 		defer ticker.Stop()
-		defer closeFile(b, f)
 		for {
 			select {
-			case number, more := <-in:
-				if more {
-					_, err := fmt.Fprintf(b, "%09d\n", number)
+			case batch, more := <-in:
+				if !more {
+					closed <- closeFile(b, f, offset, logger)
+					close(closed)
+					return
+				}
+				for _, number := range batch.Numbers {
+					n, err := writeNumber(b, number)
+					offset += int64(n)
 					if err != nil {
-						log.Printf("%v", errors.Wrap(err, "Fprintf"))
-					}
-				} else {
-					if err := b.Flush(); err != nil {
-						log.Printf("%v", err)
+						logger.Printf("%v", errors.Wrap(err, "writeNumber"))
 					}
-					close(done)
-					return
 				}
+				atomic.AddInt64(&metrics.written, int64(len(batch.Numbers)))
+				batch.Release()
 			case <-ticker.C:
 				if err := b.Flush(); err != nil {
-					log.Printf("%v", err)
+					logger.Printf("%v", err)
 				}
+			case reply := <-syncs:
+				reply <- syncFile(b, f, offset)
 			}
 
 		}
 	}()
-	return done
+	return closed
 }
 
-func closeFile(b *bufio.Writer, f *os.File) {
-	if err := f.Close(); err != nil {
-		log.Printf("%v", err)
+// writeNumber writes the number as a line of 9 digits, as "%09d\n" does without allocating.
+func writeNumber(b *bufio.Writer, number int) (int, error) {
+	var line [lineLength]byte
+	line[lineLength-1] = '\n'
+	for i := lineLength - 2; i >= 0; i-- {
+		line[i] = byte('0' + number%10)
+		number /= 10
+	}
+	return b.Write(line[:])
+}
+
+func syncFile(b *bufio.Writer, f *os.File, offset int64) logSync {
+	if err := b.Flush(); err != nil {
+		return logSync{err: errors.Wrap(err, "Flush")}
+	}
+	if err := f.Sync(); err != nil {
+		return logSync{err: errors.Wrap(err, "Sync")}
+	}
+	return logSync{offset: offset}
+}
+
+// closeFile flushes, fsyncs and closes f, so no number written is lost.
+func closeFile(b *bufio.Writer, f *os.File, offset int64, logger *log.Logger) error {
+	synced := syncFile(b, f, offset)
+	if err := f.Close(); err != nil && synced.err == nil {
+		synced.err = errors.Wrap(err, "Close")
+	}
+	if synced.err != nil {
+		logger.Printf("%v", errors.Wrap(synced.err, "closing number log"))
+		return synced.err
 	}
+	logger.Printf("number log flushed and synced at offset %d", offset)
+	return nil
 }
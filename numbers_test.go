@@ -5,6 +5,8 @@ import (
 	"context"
 	"fmt"
 	"github.com/google/uuid"
+	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
@@ -18,7 +20,7 @@ import (
 func TestNumbersControllerReadNumber(t *testing.T) {
 	server, client := net.Pipe()
 	terminated := make(chan int)
-	numbersIn := make(chan int)
+	numbersIn := make(chan *numbers.Batch)
 	defer close(terminated)
 	defer close(numbersIn)
 	ctx, cancel := context.WithCancel(context.Background())
@@ -32,7 +34,7 @@ func TestNumbersControllerReadNumber(t *testing.T) {
 	sendData(t, client, wireNumber)
 	go numbers.DefaultTCPController(ctx, server, numbersIn, terminated)
 
-	number := <-numbersIn
+	number := (<-numbersIn).Numbers[0]
 	if expectedNumber != number {
 		t.Fatal(fmt.Errorf("number should be: %d not %d", expectedNumber, number))
 	}
@@ -41,7 +43,7 @@ func TestNumbersControllerReadNumber(t *testing.T) {
 func TestNumbersControllerNotNumber(t *testing.T) {
 	server, client := net.Pipe()
 	terminated := make(chan int)
-	numbersIn := make(chan int)
+	numbersIn := make(chan *numbers.Batch)
 	defer close(terminated)
 	defer close(numbersIn)
 	ctx, cancel := context.WithCancel(context.Background())
@@ -52,8 +54,8 @@ func TestNumbersControllerNotNumber(t *testing.T) {
 	go numbers.DefaultTCPController(ctx, server, numbersIn, terminated)
 	ticker := time.NewTicker(time.Second)
 	select {
-	case number := <-numbersIn:
-		t.Fatal(fmt.Errorf("non expected number:%d", number))
+	case batch := <-numbersIn:
+		t.Fatal(fmt.Errorf("non expected numbers:%v", batch.Numbers))
 	case <-ticker.C:
 	}
 }
@@ -61,7 +63,7 @@ func TestNumbersControllerNotNumber(t *testing.T) {
 func TestNumbersControllerClosedConnection(t *testing.T) {
 	server, _ := net.Pipe()
 	terminated := make(chan int)
-	numbersIn := make(chan int)
+	numbersIn := make(chan *numbers.Batch)
 	defer close(terminated)
 	defer close(numbersIn)
 	ctx, cancel := context.WithCancel(context.Background())
@@ -81,7 +83,7 @@ func TestNumbersControllerClosedConnection(t *testing.T) {
 func TestNumbersControllerTerminate(t *testing.T) {
 	server, client := net.Pipe()
 	terminated := make(chan int)
-	numbersIn := make(chan int)
+	numbersIn := make(chan *numbers.Batch)
 	defer close(numbersIn)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -110,72 +112,223 @@ func TestNumbersControllerTerminate(t *testing.T) {
 	}
 }
 
+func BenchmarkDefaultTCPController(b *testing.B) {
+	b.ReportAllocs()
+	numbersIn := make(chan *numbers.Batch)
+	terminated := make(chan int)
+	go func() {
+		for batch := range numbersIn {
+			batch.Release()
+		}
+	}()
+	defer close(numbersIn)
+	conn := &linesConn{line: []byte("123456789\n"), lines: b.N}
+
+	b.ResetTimer()
+	if err := numbers.DefaultTCPController(context.Background(), conn, numbersIn, terminated); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func TestDefaultTCPControllerTerminatedSendsParsedNumbers(t *testing.T) {
+	numbersIn := make(chan *numbers.Batch, 1)
+	terminate := make(chan int)
+	conn := &terminatingConn{linesConn: linesConn{line: []byte("123456789\n"), lines: 1}, terminate: terminate}
+
+	err := numbers.DefaultTCPController(context.Background(), conn, numbersIn, terminate)
+	if err != numbers.TERMINATED {
+		t.Fatal(fmt.Errorf("error should be: %v not %v", numbers.TERMINATED, err))
+	}
+	expectNumber(numbersIn, 123456789, t)
+}
+
+// terminatingConn is a linesConn that closes terminate, as another connection would, once its lines are read.
+type terminatingConn struct {
+	linesConn
+	terminate chan int
+}
+
+func (c *terminatingConn) Read(b []byte) (int, error) {
+	n, err := c.linesConn.Read(b)
+	if c.lines == 0 && n > 0 {
+		close(c.terminate)
+	}
+	return n, err
+}
+
+// linesConn is a connection reading the same line a number of times.
+type linesConn struct {
+	net.Conn
+	line  []byte
+	lines int
+}
+
+func (l *linesConn) Read(b []byte) (int, error) {
+	n := 0
+	for l.lines > 0 && len(b)-n >= len(l.line) {
+		n += copy(b[n:], l.line)
+		l.lines--
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (l *linesConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
 func sendData(t *testing.T, client net.Conn, wireNumber string) {
 	go func() {
 		_, err := client.Write([]byte(wireNumber + "\n"))
 		if err != nil {
-			t.Fatal(err)
+			t.Error(err)
 		}
 	}()
 }
 
 func TestNewNumberStoreNumber(t *testing.T) {
-	numbersIn := make(chan int, 2)
+	numbersIn := make(chan *numbers.Batch, 2)
 	defer close(numbersIn)
 
 	expectedNumber := 123456789
-	numbersIn <- expectedNumber
+	numbersIn <- batchOf(expectedNumber)
 
 	terminate := make(chan int)
 	defer close(terminate)
-	numberOut := numbers.NumberStore(10, []chan int{numbersIn}, terminate)
+	numberOut := numbers.NumberStore(10, numbers.NewBitSet(maxNumbers), []chan *numbers.Batch{numbersIn}, terminate)
 
 	expectNumber(numberOut, expectedNumber, t)
 }
 
 func TestNewNumberStoreTwoNumbers(t *testing.T) {
-	numbersIn := make(chan int, 2)
+	numbersIn := make(chan *numbers.Batch, 2)
 	defer close(numbersIn)
 	expectedNumber1 := 123456789
 	expectedNumber2 := 987654321
-	numbersIn <- expectedNumber1
-	numbersIn <- expectedNumber2
+	numbersIn <- batchOf(expectedNumber1)
+	numbersIn <- batchOf(expectedNumber2)
 
 	terminate := make(chan int)
 	defer close(terminate)
-	numberOut := numbers.NumberStore(10, []chan int{numbersIn}, terminate)
+	numberOut := numbers.NumberStore(10, numbers.NewBitSet(maxNumbers), []chan *numbers.Batch{numbersIn}, terminate)
 
 	expectNumber(numberOut, expectedNumber1, t)
 	expectNumber(numberOut, expectedNumber2, t)
 }
 
 func TestNewNumberStoreDeduplicated(t *testing.T) {
-	numbersIn := make(chan int, 2)
+	numbersIn := make(chan *numbers.Batch, 2)
 	defer close(numbersIn)
 	expectedNumber := 123456789
 
-	numbersIn <- expectedNumber
-	numbersIn <- expectedNumber
+	numbersIn <- batchOf(expectedNumber)
+	numbersIn <- batchOf(expectedNumber)
 
 	terminate := make(chan int)
 	defer close(terminate)
-	numberOut := numbers.NumberStore(10, []chan int{numbersIn}, terminate)
+	numberOut := numbers.NumberStore(10, numbers.NewBitSet(maxNumbers), []chan *numbers.Batch{numbersIn}, terminate)
 
 	expectNumber(numberOut, expectedNumber, t)
 	numberNotExpected(numberOut, t)
 }
 
 func TestNewNumberStoreCloseInChannel(t *testing.T) {
-	numbersIn := make(chan int)
+	numbersIn := make(chan *numbers.Batch)
 	close(numbersIn)
 
 	terminate := make(chan int)
 	defer close(terminate)
-	numberOut := numbers.NumberStore(10, []chan int{numbersIn}, terminate)
+	numberOut := numbers.NumberStore(10, numbers.NewBitSet(maxNumbers), []chan *numbers.Batch{numbersIn}, terminate)
+	numberNotExpected(numberOut, t)
+}
+
+func TestShardedNumberStoreDeduplicated(t *testing.T) {
+	numbersIn := make(chan *numbers.Batch, 6)
+	defer close(numbersIn)
+	expectedNumbers := map[int]bool{1: true, 333333333: true, 666666666: true, 999999999: true}
+	for _, number := range []int{1, 333333333, 666666666, 999999999, 1, 999999999} {
+		numbersIn <- batchOf(number)
+	}
+
+	terminate := make(chan int)
+	defer close(terminate)
+	shards := numbers.NewShards(4, func(size int) numbers.Deduplicator { return numbers.NewMapSet() })
+	numberOut := numbers.ShardedNumberStore(10, shards, []chan *numbers.Batch{numbersIn}, terminate)
+
+	for len(expectedNumbers) > 0 {
+		select {
+		case batch := <-numberOut:
+			for _, number := range batch.Numbers {
+				if !expectedNumbers[number] {
+					t.Fatal(fmt.Errorf("number not expected: %d", number))
+				}
+				delete(expectedNumbers, number)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatal("timeout while waiting for a response in numberOut")
+		}
+	}
 	numberNotExpected(numberOut, t)
 }
 
-func numberNotExpected(numberOut chan int, t *testing.T) {
+func BenchmarkShardedNumberStore(b *testing.B) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	for _, count := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("%dshards", count), func(b *testing.B) {
+			inputs := 8
+			ins := make([]chan *numbers.Batch, inputs)
+			for i := range ins {
+				ins[i] = make(chan *numbers.Batch)
+			}
+			terminate := make(chan int)
+			defer close(terminate)
+			shards := numbers.NewShards(count, func(size int) numbers.Deduplicator { return numbers.NewBitSet(size) })
+			out := numbers.ShardedNumberStore(10, shards, ins, terminate)
+			drained := make(chan int)
+			go func() {
+				defer close(drained)
+				for batch := range out {
+					batch.Release()
+				}
+			}()
+
+			b.ResetTimer()
+			start := time.Now()
+			var wg sync.WaitGroup
+			wg.Add(inputs)
+			for i, in := range ins {
+				go func(i int, in chan *numbers.Batch) {
+					defer wg.Done()
+					batch := numbers.NewBatch()
+					for n := i; n < b.N; n += inputs {
+						batch.Numbers = append(batch.Numbers, n*spread%maxNumbers)
+						if len(batch.Numbers) == 256 {
+							in <- batch
+							batch = numbers.NewBatch()
+						}
+					}
+					in <- batch
+					close(in)
+				}(i, in)
+			}
+			wg.Wait()
+			<-drained
+			b.StopTimer()
+			b.ReportMetric(float64(time.Since(start).Nanoseconds())/float64(b.N), "ns/number")
+		})
+	}
+}
+
+func batchOf(numbersIn ...int) *numbers.Batch {
+	batch := numbers.NewBatch()
+	batch.Numbers = append(batch.Numbers, numbersIn...)
+	return batch
+}
+
+func numberNotExpected(numberOut chan *numbers.Batch, t *testing.T) {
 	select {
 	case _, open := <-numberOut:
 		if open {
@@ -184,11 +337,11 @@ func numberNotExpected(numberOut chan int, t *testing.T) {
 	case <-time.After(1 * time.Second):
 	}
 }
-func expectNumber(numberOut chan int, expectedNumber int, t *testing.T) {
+func expectNumber(numberOut chan *numbers.Batch, expectedNumber int, t *testing.T) {
 	select {
-	case number := <-numberOut:
-		if number != expectedNumber {
-			t.Fatal(fmt.Errorf("number should be: %d not %d", expectedNumber, number))
+	case batch := <-numberOut:
+		if len(batch.Numbers) != 1 || batch.Numbers[0] != expectedNumber {
+			t.Fatal(fmt.Errorf("numbers should be: [%d] not %v", expectedNumber, batch.Numbers))
 		}
 	case <-time.After(1 * time.Second):
 		t.Fatal("timeout while waiting for a response in numberOut")
@@ -196,9 +349,9 @@ func expectNumber(numberOut chan int, expectedNumber int, t *testing.T) {
 }
 
 func TestNewFileWriter(t *testing.T) {
-	numbersIn := make(chan int, 2)
+	numbersIn := make(chan *numbers.Batch, 2)
 	expectedNumber := 123456789
-	numbersIn <- expectedNumber
+	numbersIn <- batchOf(expectedNumber)
 
 	dir, err := os.Getwd()
 	if err != nil {
@@ -220,7 +373,7 @@ func TestNewFileWriter(t *testing.T) {
 }
 
 func TestNewFileWriterOverwriteWhenStarted(t *testing.T) {
-	numbersIn := make(chan int, 2)
+	numbersIn := make(chan *numbers.Batch, 2)
 	defer close(numbersIn)
 
 	nonExpectedNumber := 123456789
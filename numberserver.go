@@ -0,0 +1,402 @@
+package numbers
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// standardLogger logs through the standard logger of the log package, so it follows its output, prefix and flags.
+var standardLogger = log.New(standardLog{}, "", 0)
+
+type standardLog struct{}
+
+func (standardLog) Write(p []byte) (int, error) {
+	log.Print(string(p))
+	return len(p), nil
+}
+
+// ErrShutdownCanceled is the error of a server terminated because its shutdown was canceled
+// before the connections were drained.
+var ErrShutdownCanceled = errors.New("shutdown canceled before the connections were drained")
+
+// Server is a number server, it deduplicates the numbers of its clients and writes the unique ones to its number log.
+// It runs from Start until it is terminated, by a terminate line or frame, by the admin channel or by canceling
+// the context it was started with, or until it is shut down.
+type Server struct {
+	options Options
+	logger  *log.Logger
+	started int32
+	status  *status
+	// listener is the one of Address and Listen and binaryListener the one of BinaryAddress, if any.
+	listener       *multiListener
+	binaryListener *multiListener
+	// serving is canceled once the server is terminated, cancel terminates it.
+	serving       context.Context
+	cancel        context.CancelFunc
+	stopAccepting chan int
+	draining      sync.Once
+	// pools serve the connections of the listeners, they are resized together once accepting is set.
+	pools     []*workerPool
+	accepting int32
+	// done is closed once the server is stopped, err is why.
+	done chan int
+	err  error
+}
+
+// NewServer returns a Server configured by options, ready to be started.
+func NewServer(options Options) *Server {
+	logger := options.Logger
+	if logger == nil {
+		logger = standardLogger
+	}
+	return &Server{
+		options:       options,
+		logger:        logger,
+		stopAccepting: make(chan int),
+		done:          make(chan int),
+	}
+}
+
+// StartNumberServer starts a Server with the given options and serves until it is terminated.
+// On a SIGINT or a SIGTERM the server is shut down, its connections are drained for up to the grace period,
+// or until a second signal. On a SIGHUP the options are reloaded, if there is a Reload.
+func StartNumberServer(options Options) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	reloads := make(chan os.Signal, 1)
+	if options.Reload != nil {
+		signal.Notify(reloads, syscall.SIGHUP)
+		defer signal.Stop(reloads)
+	}
+	server := NewServer(options)
+	if err := server.Start(context.Background()); err != nil {
+		return err
+	}
+	if options.Reload != nil {
+		go reloadOnSignal(server, reloads, options.Reload)
+	}
+	return shutdownOnSignal(server, signals, options.GracePeriod)
+}
+
+// reloadOnSignal reloads the options on every signal and applies their concurrent connections,
+// until the server stops.
+func reloadOnSignal(server *Server, signals chan os.Signal, reload func() (Options, error)) {
+	for {
+		select {
+		case <-server.done:
+			return
+		case sig := <-signals:
+			server.logger.Printf("%v received, reloading the options", sig)
+			options, err := reload()
+			if err != nil {
+				server.logger.Printf("%v", errors.Wrap(err, "reload"))
+				continue
+			}
+			connections := options.ConcurrentConnections
+			if connections == 0 {
+				connections = defaultConcurrentConnections
+			}
+			if err := server.SetConcurrentConnections(connections); err != nil {
+				server.logger.Printf("%v", errors.Wrap(err, "reload"))
+			}
+		}
+	}
+}
+
+// Start restores the numbers, if resuming, and starts serving. It returns once the server accepts connections,
+// or the error starting it. Canceling ctx terminates the server as a terminate line does.
+func (s *Server) Start(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		return errors.New("server already started")
+	}
+	s.serving, s.cancel = context.WithCancel(ctx)
+	if err := s.start(s.serving); err != nil {
+		s.err = err
+		s.cancel()
+		close(s.done)
+		return err
+	}
+	return nil
+}
+
+func (s *Server) start(ctx context.Context) error {
+	options := s.options
+	if options.ConcurrentConnections == 0 {
+		options.ConcurrentConnections = defaultConcurrentConnections
+	}
+	if err := checkConcurrentConnections(options.ConcurrentConnections); err != nil {
+		return err
+	}
+	if options.Shards < 0 {
+		return fmt.Errorf("shards should be more than 0, not %d", options.Shards)
+	}
+	if options.Shards == 0 {
+		options.Shards = runtime.NumCPU()
+	}
+	if options.Dedup == nil {
+		options.Dedup = func(size int) Deduplicator { return NewBitSet(size) }
+	}
+	if options.OutputPath == "" {
+		options.OutputPath = numberLogFileName
+	}
+	if options.ReportPeriod <= 0 {
+		options.ReportPeriod = defaultReportPeriod
+	}
+	shards := NewShards(options.Shards, options.Dedup)
+	if options.QueryFirstSeen {
+		shards.TrackFirstSeen()
+	}
+	rejections := &Rejections{}
+	s.status = newStatus(rejections)
+	if options.HTTPAddress != "" {
+		if err := startHTTP(options.HTTPAddress, s.status, options.History, s.done, s.logger); err != nil {
+			return err
+		}
+	}
+	var total int64
+	if options.Resume {
+		var err error
+		if total, err = restore(options.OutputPath, options.SnapshotPath, shards, s.logger); err != nil {
+			return err
+		}
+	} else if options.SnapshotPath != "" {
+		if err := os.Remove(options.SnapshotPath); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "remove snapshot")
+		}
+	}
+	f, offset, err := openNumberLog(options.OutputPath, options.Resume, s.logger)
+	if err != nil {
+		return err
+	}
+	if err := s.listen(ctx, listenAddresses(options), options.BinaryAddress, options.UnixSocket); err != nil {
+		closeLog(f, s.logger)
+		return err
+	}
+	syncs := make(chan chan logSync)
+	requests := make(chan storeRequest)
+	if options.AdminAddress != "" {
+		control := &admin{token: options.AdminToken, terminate: s.cancel, terminated: ctx.Done(), syncs: syncs,
+			requests: requests, resize: s.SetConcurrentConnections, logger: s.logger}
+		if err := startAdmin(options.AdminNetwork, options.AdminAddress, control, s.done); err != nil {
+			s.closeListeners()
+			closeLog(f, s.logger)
+			return err
+		}
+	}
+	if options.QueryAddress != "" {
+		if err := startQueries(options.QueryAddress, shards, s.done, s.logger); err != nil {
+			s.closeListeners()
+			closeLog(f, s.logger)
+			return err
+		}
+	}
+
+	controller := options.Controller
+	if controller == nil {
+		controller = NewTCPControllerV2(options.InputPolicy, rejections)
+		if options.Ack {
+			controller = NewAckTCPControllerV2(options.InputPolicy, rejections)
+		}
+	}
+	controller = s.status.countConnections(controller)
+	joins := make(chan chan *Batch)
+	workers, numbersOuts := newWorkerPool(options.ConcurrentConnections, controller, options.Capacity, s.cancel,
+		joins, s.status, s.logger)
+	s.pools = []*workerPool{workers}
+	var binaryWorkers *workerPool
+	if s.binaryListener != nil {
+		var binaryOuts []chan *Batch
+		binaryController := s.status.countConnections(NewBinaryTCPControllerV2(options.InputPolicy))
+		binaryWorkers, binaryOuts = newWorkerPool(options.ConcurrentConnections, binaryController, options.Capacity,
+			s.cancel, joins, s.status, s.logger)
+		numbersOuts = append(numbersOuts, binaryOuts...)
+		s.pools = append(s.pools, binaryWorkers)
+	}
+	go func() {
+		for _, pool := range s.pools {
+			<-pool.closed
+		}
+		close(joins)
+	}()
+	var snapshots *checkpoint
+	if options.SnapshotPath != "" && options.SnapshotPeriod > 0 {
+		snapshots = &checkpoint{path: options.SnapshotPath, period: options.SnapshotPeriod, syncs: syncs,
+			logger: s.logger}
+	}
+	counters := newStoreCounters(total, shards.Count())
+	s.status.counters.Store(counters)
+	reporters := options.Reporters
+	if len(reporters) == 0 {
+		reporters = []Reporter{NewLoggerReporter(s.logger)}
+	}
+	if options.History != nil {
+		reporters = append(reporters[:len(reporters):len(reporters)], options.History)
+	}
+	deDuplicatedNumbers := numberStore(options.ReportPeriod, reporters, shards, numbersOuts, joins, ctx.Done(),
+		counters, rejections, snapshots, requests, s.logger)
+	logClosed := fileWriter(deDuplicatedNumbers, f, offset, syncs, s.status.writer, s.logger)
+
+	if binaryWorkers != nil {
+		s.serve(ctx, s.binaryListener, binaryWorkers)
+	}
+	s.serve(ctx, s.listener, workers)
+	s.status.setState(StateServing)
+	atomic.StoreInt32(&s.accepting, 1)
+	go s.run(logClosed)
+	return nil
+}
+
+// listenAddresses returns the addresses the text protocol is served at, Address followed by Listen.
+// Address is left out when empty and there are others.
+func listenAddresses(options Options) []string {
+	if options.Address == "" && len(options.Listen) > 0 {
+		return options.Listen
+	}
+	return append([]string{options.Address}, options.Listen...)
+}
+
+// listen listens at the addresses and, if any, at the binary address.
+func (s *Server) listen(ctx context.Context, addresses []string, binaryAddress string, socket UnixSocket) error {
+	l, err := listenAll(ctx, addresses, socket, s.logger)
+	if err != nil {
+		return err
+	}
+	s.listener = l
+	if binaryAddress != "" {
+		binaryListener, err := listenAll(ctx, []string{binaryAddress}, socket, s.logger)
+		if err != nil {
+			s.closeListeners()
+			return err
+		}
+		s.binaryListener = binaryListener
+	}
+	return nil
+}
+
+func (s *Server) closeListeners() {
+	for _, l := range []*multiListener{s.listener, s.binaryListener} {
+		if l != nil {
+			closeListener(l, s.logger)
+		}
+	}
+}
+
+// serve accepts connections for the workers until the server stops accepting them.
+func (s *Server) serve(ctx context.Context, l *multiListener, workers *workerPool) {
+	for _, address := range l.addrs() {
+		s.logger.Printf("server started at:%s", address.String())
+	}
+	go workers.listen(ctx, l)
+	go func() {
+		<-s.stopAccepting
+		closeListener(l, s.logger)
+	}()
+}
+
+// run waits for the server to stop accepting connections, once terminated or shut down, and then for
+// the number log to be closed.
+func (s *Server) run(logClosed chan error) {
+	select {
+	case <-s.serving.Done():
+		s.drain()
+	case err := <-logClosed:
+		s.logger.Printf("connections drained")
+		s.stop(err)
+		return
+	}
+	s.stop(<-logClosed)
+}
+
+// drain stops accepting connections, the ones in flight are served until their clients close them.
+func (s *Server) drain() {
+	s.draining.Do(func() {
+		s.status.setState(StateDraining)
+		close(s.stopAccepting)
+	})
+}
+
+func (s *Server) stop(err error) {
+	s.err = err
+	s.cancel()
+	close(s.done)
+}
+
+// Shutdown stops accepting connections and waits for the ones in flight to be closed by their clients
+// and their numbers to be stored. If ctx is done before, the server is terminated as a terminate line does.
+// Returns the error of the server, or ErrGracePeriodExpired or ErrShutdownCanceled if the connections
+// were not drained before ctx was done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if atomic.LoadInt32(&s.started) == 0 {
+		return errors.New("server not started")
+	}
+	select {
+	case <-s.done:
+		return s.err
+	default:
+	}
+	s.drain()
+	select {
+	case <-s.done:
+		return s.err
+	case <-s.serving.Done():
+		return s.Wait()
+	case <-ctx.Done():
+	}
+	err := ErrGracePeriodExpired
+	if ctx.Err() == context.Canceled {
+		err = ErrShutdownCanceled
+	}
+	s.logger.Printf("%v", err)
+	s.cancel()
+	if waitErr := s.Wait(); waitErr != nil {
+		return waitErr
+	}
+	return err
+}
+
+// SetConcurrentConnections changes how many connections are served at the same time while the server accepts
+// connections. Workers are started right away, busy ones are retired once their connection is served.
+func (s *Server) SetConcurrentConnections(n int) error {
+	if atomic.LoadInt32(&s.accepting) == 0 {
+		return errors.New("server not accepting connections")
+	}
+	for _, pool := range s.pools {
+		if err := pool.resize(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Wait waits for the server to stop and returns why, nil once the number log is closed without errors.
+func (s *Server) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Addr returns the address the server listens at, with the actual port when started at port 0.
+// It is the first one when listening at several. It is nil until the server is started.
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Addrs returns all the addresses the server listens at for the text protocol, nil until the server is started.
+func (s *Server) Addrs() []net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.addrs()
+}
@@ -0,0 +1,274 @@
+package numbers_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"tgracchus/numbers"
+	"time"
+)
+
+// syncBuffer is a buffer safe to log to while it is read.
+type syncBuffer struct {
+	mux sync.Mutex
+	b   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.b.String()
+}
+
+// newTestServer returns a server at an ephemeral port writing its number log to a temporary directory.
+func newTestServer(t *testing.T, options numbers.Options) (*numbers.Server, string) {
+	dir, err := ioutil.TempDir("", "numbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	options.Address = "localhost:0"
+	options.OutputPath = filepath.Join(dir, "numbers.log")
+	options.Dedup = func(size int) numbers.Deduplicator { return numbers.NewMapSet() }
+	options.Shards = 2
+	return numbers.NewServer(options), options.OutputPath
+}
+
+func TestServerShutdownDrainsConnections(t *testing.T) {
+	logs := &syncBuffer{}
+	server, outputPath := newTestServer(t, numbers.Options{Ack: true, Logger: log.New(logs, "", 0)})
+	if server.Addr() != nil {
+		t.Fatal(fmt.Errorf("addr should be nil before start, not %v", server.Addr()))
+	}
+	if err := server.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	address := server.Addr().(*net.TCPAddr)
+	if address.Port == 0 {
+		t.Fatal("server should listen at an ephemeral port")
+	}
+	conn, err := net.Dial("tcp", address.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("000000001\n000000002\n000000001\n")); err != nil {
+		t.Fatal(err)
+	}
+	replies := bufio.NewReader(conn)
+	for i := 0; i < 3; i++ {
+		if _, err := replies.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- server.Shutdown(ctx)
+	}()
+	conn.Close()
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "000000001\n000000002\n" && string(content) != "000000002\n000000001\n" {
+		t.Fatal(fmt.Errorf("number log should hold 1 and 2, not %q", content))
+	}
+	if !strings.Contains(logs.String(), "server started at:"+address.String()) {
+		t.Fatal(fmt.Errorf("logger should have the server start, not %s", logs.String()))
+	}
+}
+
+func TestServerShutdownGracePeriodExpires(t *testing.T) {
+	server, _ := newTestServer(t, numbers.Options{})
+	if err := server.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("000000001\n")); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != numbers.ErrGracePeriodExpired {
+		t.Fatal(fmt.Errorf("error should be: %v not %v", numbers.ErrGracePeriodExpired, err))
+	}
+}
+
+func TestServerTerminatedByContext(t *testing.T) {
+	server, outputPath := newTestServer(t, numbers.Options{Ack: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := server.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("000000007\n")); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "NEW\n" {
+		t.Fatal(fmt.Errorf("reply should be: NEW not %q", reply))
+	}
+	cancel()
+	if err := server.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "000000007\n" {
+		t.Fatal(fmt.Errorf("number log should hold 7, not %q", content))
+	}
+}
+
+func TestServerStartErrors(t *testing.T) {
+	busy, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	server, outputPath := newTestServer(t, numbers.Options{})
+	for name, options := range map[string]numbers.Options{
+		"address in use":       {Address: busy.Addr().String(), OutputPath: outputPath + ".busy"},
+		"negative connections": {ConcurrentConnections: -1},
+		"too many connections": {ConcurrentConnections: 70000},
+	} {
+		server := numbers.NewServer(options)
+		err := server.Start(context.Background())
+		if err == nil {
+			t.Fatal(fmt.Errorf("%s: start should fail", name))
+		}
+		if waitErr := server.Wait(); waitErr != err {
+			t.Fatal(fmt.Errorf("%s: wait should return: %v not %v", name, err, waitErr))
+		}
+	}
+	if err := server.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(context.Background()); err == nil {
+		t.Fatal("a second start should fail")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// dialAck sends the number to the server and expects it to be acknowledged as new.
+func dialAck(t *testing.T, server *numbers.Server, number string) net.Conn {
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectAck(t, conn, number)
+	return conn
+}
+
+func expectAck(t *testing.T, conn net.Conn, number string) {
+	if _, err := conn.Write([]byte(number + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "NEW\n" {
+		t.Fatal(fmt.Errorf("reply to %s should be: NEW not %q", number, reply))
+	}
+}
+
+func TestServerSetConcurrentConnections(t *testing.T) {
+	server, outputPath := newTestServer(t, numbers.Options{
+		ConcurrentConnections: 1,
+		Capacity:              numbers.Capacity{Policy: numbers.RejectConnections},
+		Ack:                   true,
+	})
+	if err := server.SetConcurrentConnections(2); err == nil {
+		t.Fatal("a server not started should not be resized")
+	}
+	if err := server.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	first := dialAck(t, server, "000000001")
+	defer first.Close()
+	expectBusy(t, server.Addr())
+
+	if err := server.SetConcurrentConnections(2); err != nil {
+		t.Fatal(err)
+	}
+	second := dialAck(t, server, "000000002")
+	if err := server.SetConcurrentConnections(1); err != nil {
+		t.Fatal(err)
+	}
+	expectAck(t, first, "000000003")
+	expectAck(t, second, "000000004")
+	second.Close()
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the worker of the second connection should be retired")
+		}
+		conn, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		reply, _ := ioutil.ReadAll(conn)
+		conn.Close()
+		if string(reply) == "ERR busy\n" {
+			break
+		}
+	}
+
+	first.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.SetConcurrentConnections(2); err == nil {
+		t.Fatal("a server shut down should not be resized")
+	}
+	content, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logged := strings.Fields(string(content)); len(logged) != 4 {
+		t.Fatal(fmt.Errorf("number log should hold 4 numbers, not %q", content))
+	}
+}
@@ -0,0 +1,188 @@
+package numbers
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"sync"
+	"sync/atomic"
+)
+
+// Reasons for a line to be rejected, a LineError unwraps to one of them.
+var (
+	ErrWrongLength    = errors.New("wrong length, not 9 chars")
+	ErrNonDigit       = errors.New("non digit")
+	ErrSign           = errors.New("sign")
+	ErrMissingNewLine = errors.New("missing new line")
+)
+
+// rejectReasons are all the reasons, in the order they are reported.
+var rejectReasons = []error{ErrWrongLength, ErrNonDigit, ErrSign, ErrMissingNewLine}
+
+// LineError is the error of a line rejected by a controller, it unwraps to the Reason.
+type LineError struct {
+	// Reason is one of ErrWrongLength, ErrNonDigit, ErrSign or ErrMissingNewLine.
+	Reason error
+	// Position of the rejected char, for ErrNonDigit and ErrSign.
+	Position int
+	// Length of the line without its new line, for ErrWrongLength.
+	Length int
+}
+
+func (e *LineError) Error() string {
+	switch e.Reason {
+	case ErrNonDigit, ErrSign:
+		return fmt.Sprintf("%v at position %d", e.Reason, e.Position)
+	case ErrWrongLength:
+		return fmt.Sprintf("%v, %d chars", e.Reason, e.Length)
+	default:
+		return e.Reason.Error()
+	}
+}
+
+// Unwrap returns the reason, so errors.Is matches the line error with it.
+func (e *LineError) Unwrap() error {
+	return e.Reason
+}
+
+var terminateSentinel = []byte("terminate")
+
+// parseLine parses a line read from a client straight from the read buffer, without allocating unless rejected.
+// A line is either exactly 9 digits or the terminate sentinel, followed by a new line. With withToken the
+// sentinel can be followed by a space and a token, returned as a slice of line.
+func parseLine(line []byte, withToken bool) (int, bool, []byte, error) {
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		return 0, false, nil, &LineError{Reason: ErrMissingNewLine}
+	}
+	data := line[:len(line)-1]
+	if bytes.HasPrefix(data, terminateSentinel) {
+		if len(data) == len(terminateSentinel) {
+			return 0, true, nil, nil
+		}
+		if withToken && data[len(terminateSentinel)] == ' ' {
+			return 0, true, data[len(terminateSentinel)+1:], nil
+		}
+	}
+	number, err := parseNumber(data)
+	return number, false, nil, err
+}
+
+// parseNumber parses exactly 9 digits, without allocating unless rejected.
+func parseNumber(data []byte) (int, error) {
+	if len(data) != 9 {
+		return 0, &LineError{Reason: ErrWrongLength, Length: len(data)}
+	}
+	for i, digit := range data {
+		if i == 0 && (digit == '+' || digit == '-') {
+			return 0, &LineError{Reason: ErrSign, Position: i}
+		}
+		if digit < '0' || digit > '9' {
+			return 0, &LineError{Reason: ErrNonDigit, Position: i}
+		}
+	}
+	return parseDigits(data), nil
+}
+
+// Rejections counts the lines rejected by reason and the clients disconnected for too many of them.
+// It is safe to use from several goroutines.
+type Rejections struct {
+	counts       [4]int64
+	disconnected int64
+	// resetCounts and resetDisconnected are the counts at the last reset, the ones before are not reported.
+	resetCounts       [4]int64
+	resetDisconnected int64
+	mux               sync.Mutex
+	policies          []InputPolicy
+}
+
+// Count returns how many lines have been rejected for the reason since the last reset.
+func (r *Rejections) Count(reason error) int64 {
+	for i, rejectReason := range rejectReasons {
+		if rejectReason == reason {
+			return atomic.LoadInt64(&r.counts[i]) - atomic.LoadInt64(&r.resetCounts[i])
+		}
+	}
+	return 0
+}
+
+// add counts the line rejected with err, if it is a LineError.
+func (r *Rejections) add(err error) {
+	lineError, ok := err.(*LineError)
+	if r == nil || !ok {
+		return
+	}
+	for i, reason := range rejectReasons {
+		if reason == lineError.Reason {
+			atomic.AddInt64(&r.counts[i], 1)
+		}
+	}
+}
+
+// Disconnected returns how many clients have been disconnected for too many rejected lines since the last reset.
+func (r *Rejections) Disconnected() int64 {
+	return atomic.LoadInt64(&r.disconnected) - atomic.LoadInt64(&r.resetDisconnected)
+}
+
+func (r *Rejections) disconnect() {
+	if r != nil {
+		atomic.AddInt64(&r.disconnected, 1)
+	}
+}
+
+// reset makes all the counts start again from 0, the totals since the server started are kept.
+func (r *Rejections) reset() {
+	if r == nil {
+		return
+	}
+	for i := range r.counts {
+		atomic.StoreInt64(&r.resetCounts[i], atomic.LoadInt64(&r.counts[i]))
+	}
+	atomic.StoreInt64(&r.resetDisconnected, atomic.LoadInt64(&r.disconnected))
+}
+
+// addPolicy records the policy of a controller counting its rejections here, for the reports.
+func (r *Rejections) addPolicy(policy InputPolicy) {
+	if r == nil {
+		return
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for _, added := range r.policies {
+		if added == policy {
+			return
+		}
+	}
+	r.policies = append(r.policies, policy)
+}
+
+// policy describes the policies of the controllers counting their rejections here.
+func (r *Rejections) policy() string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	description := ""
+	for i, policy := range r.policies {
+		if i > 0 {
+			description += "; "
+		}
+		description += policy.String()
+	}
+	return description
+}
+
+// all returns the counts of all the reasons since the last reset.
+func (r *Rejections) all() [4]int64 {
+	counts := r.totals()
+	for i := range counts {
+		counts[i] -= atomic.LoadInt64(&r.resetCounts[i])
+	}
+	return counts
+}
+
+// totals returns the counts of all the reasons since the server started, they are never reset.
+func (r *Rejections) totals() [4]int64 {
+	var counts [4]int64
+	for i := range counts {
+		counts[i] = atomic.LoadInt64(&r.counts[i])
+	}
+	return counts
+}
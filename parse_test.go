@@ -0,0 +1,54 @@
+package numbers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"tgracchus/numbers"
+)
+
+func TestNumbersControllerRejectionReasons(t *testing.T) {
+	tests := []struct {
+		wire     string
+		reason   error
+		position int
+	}{
+		{"12345678\n", numbers.ErrWrongLength, 0},
+		{"1234567890\n", numbers.ErrWrongLength, 0},
+		{"terminat\n", numbers.ErrWrongLength, 0},
+		{"+12345678\n", numbers.ErrSign, 0},
+		{"-12345678\n", numbers.ErrSign, 0},
+		{"1234-5678\n", numbers.ErrNonDigit, 4},
+		{"12345678a\n", numbers.ErrNonDigit, 8},
+		{"1234 5678\n", numbers.ErrNonDigit, 4},
+		{"123456789", numbers.ErrMissingNewLine, 0},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%q", test.wire), func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			go func() {
+				if _, err := client.Write([]byte(test.wire)); err != nil {
+					t.Error(err)
+				}
+				client.Close()
+			}()
+			rejections := &numbers.Rejections{}
+			controller := numbers.NewTCPController(numbers.InputPolicy{}, rejections)
+
+			err := controller(context.Background(), server, make(chan *numbers.Batch), make(chan int))
+			if !errors.Is(err, test.reason) {
+				t.Fatal(fmt.Errorf("error should be: %v not %v", test.reason, err))
+			}
+			var lineError *numbers.LineError
+			if !errors.As(err, &lineError) || lineError.Position != test.position {
+				t.Fatal(fmt.Errorf("rejected position should be: %d not %v", test.position, err))
+			}
+			if rejections.Count(test.reason) != 1 {
+				t.Fatal(fmt.Errorf("one %v rejection should have been counted", test.reason))
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package numbers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"time"
+)
+
+// InvalidInputPolicy says what a controller does when it rejects a line.
+type InvalidInputPolicy int
+
+const (
+	// Disconnect closes the connection on the first rejected line.
+	Disconnect InvalidInputPolicy = iota
+	// Skip counts the rejected line and goes on with the next one.
+	Skip
+	// SkipAndReply works as Skip and also writes back an "ERR <reason>" line to the client.
+	SkipAndReply
+)
+
+var invalidInputPolicyNames = []string{"disconnect", "skip", "reply"}
+
+func (p InvalidInputPolicy) String() string {
+	if p < 0 || int(p) >= len(invalidInputPolicyNames) {
+		return fmt.Sprintf("InvalidInputPolicy(%d)", int(p))
+	}
+	return invalidInputPolicyNames[p]
+}
+
+// ParseInvalidInputPolicy returns the policy with the given name: disconnect, skip or reply.
+func ParseInvalidInputPolicy(name string) (InvalidInputPolicy, error) {
+	for policy, policyName := range invalidInputPolicyNames {
+		if name == policyName {
+			return InvalidInputPolicy(policy), nil
+		}
+	}
+	return Disconnect, fmt.Errorf("unknown invalid input policy %s, should be one of disconnect, skip or reply", name)
+}
+
+// TerminatePolicy says what a controller does with a terminate line on the data port.
+type TerminatePolicy int
+
+const (
+	// TerminateAllowed terminates the server on any terminate line.
+	TerminateAllowed TerminatePolicy = iota
+	// TerminateDisabled refuses every terminate line, the server is only terminated through the admin channel.
+	TerminateDisabled
+	// TerminateWithToken only terminates the server on a "terminate <token>" line with the right token.
+	TerminateWithToken
+)
+
+var terminatePolicyNames = []string{"allowed", "disabled", "token"}
+
+func (p TerminatePolicy) String() string {
+	if p < 0 || int(p) >= len(terminatePolicyNames) {
+		return fmt.Sprintf("TerminatePolicy(%d)", int(p))
+	}
+	return terminatePolicyNames[p]
+}
+
+// ParseTerminatePolicy returns the policy with the given name: allowed, disabled or token.
+func ParseTerminatePolicy(name string) (TerminatePolicy, error) {
+	for policy, policyName := range terminatePolicyNames {
+		if name == policyName {
+			return TerminatePolicy(policy), nil
+		}
+	}
+	return TerminateAllowed, fmt.Errorf("unknown terminate policy %s, should be one of allowed, disabled or token", name)
+}
+
+// ErrTerminateRefused is the error of a client disconnected for a terminate line the policy does not allow.
+var ErrTerminateRefused = errors.New("terminate refused")
+
+// ErrTooManyInvalidLines is the error of a client disconnected for sending too many invalid lines.
+var ErrTooManyInvalidLines = errors.New("too many invalid lines")
+
+// InputPolicy configures how a controller handles invalid input.
+type InputPolicy struct {
+	// OnInvalid is what to do with a rejected line.
+	OnInvalid InvalidInputPolicy
+	// MaxInvalid disconnects a client once MaxInvalid of its lines are rejected within InvalidWindow,
+	// even if OnInvalid skips them. There is no limit when it is 0.
+	MaxInvalid int
+	// InvalidWindow is the time window for MaxInvalid, the whole connection when it is 0.
+	InvalidWindow time.Duration
+	// Terminate is what to do with a terminate line.
+	Terminate TerminatePolicy
+	// TerminateToken is the token of the terminate lines for TerminateWithToken.
+	TerminateToken string
+}
+
+func (p InputPolicy) String() string {
+	onInvalid := p.OnInvalid.String()
+	if p.MaxInvalid != 0 && p.OnInvalid != Disconnect {
+		if p.InvalidWindow == 0 {
+			onInvalid = fmt.Sprintf("%v, disconnect after %d", p.OnInvalid, p.MaxInvalid)
+		} else {
+			onInvalid = fmt.Sprintf("%v, disconnect after %d in %v", p.OnInvalid, p.MaxInvalid, p.InvalidWindow)
+		}
+	}
+	return fmt.Sprintf("%s, terminate %v", onInvalid, p.Terminate)
+}
+
+// terminate returns an error if the policy does not allow a terminate line with the token.
+func (p InputPolicy) terminate(c net.Conn, token []byte) error {
+	switch {
+	case p.Terminate == TerminateAllowed:
+		return nil
+	case p.Terminate == TerminateWithToken && validToken(token, p.TerminateToken):
+		return nil
+	}
+	return fmt.Errorf("client: %s, %w, policy %v", c.RemoteAddr().String(), ErrTerminateRefused, p.Terminate)
+}
+
+// validToken compares token with the expected one in constant time, an empty expected token is never valid.
+func validToken(token []byte, expected string) bool {
+	return expected != "" && subtle.ConstantTimeCompare(token, []byte(expected)) == 1
+}
+
+// reject handles a line rejected with err as the policy says.
+// Returns an error when the client has to be disconnected.
+func (p InputPolicy) reject(c net.Conn, err error, invalid *invalidLines, rejections *Rejections) error {
+	if err := p.check(c, err, invalid, rejections); err != nil {
+		return err
+	}
+	if p.OnInvalid == SkipAndReply {
+		if err := c.SetWriteDeadline(time.Now().Add(readDeadline)); err != nil {
+			return errors.Wrap(err, "SetWriteDeadline")
+		}
+		if _, err := fmt.Fprintf(c, "ERR %v\n", err); err != nil {
+			return errors.Wrap(err, "reply rejected line")
+		}
+	}
+	return nil
+}
+
+// check counts a line rejected with err and returns an error when the client has to be disconnected for it.
+func (p InputPolicy) check(c net.Conn, err error, invalid *invalidLines, rejections *Rejections) error {
+	rejections.add(err)
+	rejected := fmt.Errorf("parse line: client: %s, %w", c.RemoteAddr().String(), err)
+	if p.OnInvalid == Disconnect {
+		return rejected
+	}
+	if invalid.tooMany(time.Now()) {
+		rejections.disconnect()
+		return fmt.Errorf("%w, policy %v: %v", ErrTooManyInvalidLines, p, rejected)
+	}
+	return nil
+}
+
+// invalidLines remembers when the last lines of a client were rejected.
+type invalidLines struct {
+	times  []time.Time
+	next   int
+	window time.Duration
+}
+
+// newInvalidLines returns the invalidLines for the policy, nil if there is no limit.
+func newInvalidLines(policy InputPolicy) *invalidLines {
+	if policy.MaxInvalid <= 0 {
+		return nil
+	}
+	return &invalidLines{times: make([]time.Time, policy.MaxInvalid), window: policy.InvalidWindow}
+}
+
+// tooMany records a line rejected at now and returns if it is over the limit.
+func (l *invalidLines) tooMany(now time.Time) bool {
+	if l == nil {
+		return false
+	}
+	l.times[l.next] = now
+	l.next = (l.next + 1) % len(l.times)
+	oldest := l.times[l.next]
+	return !oldest.IsZero() && (l.window == 0 || now.Sub(oldest) <= l.window)
+}
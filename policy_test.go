@@ -0,0 +1,164 @@
+package numbers_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"tgracchus/numbers"
+	"time"
+)
+
+func TestSkipInvalidInputPolicy(t *testing.T) {
+	wire := "123456789\n+12345678\n" + strings.Repeat("1", 5000) + "\n987654321\n12345"
+	rejections := &numbers.Rejections{}
+	controller := numbers.NewTCPController(numbers.InputPolicy{OnInvalid: numbers.Skip}, rejections)
+
+	received, err, _ := runController(t, controller, wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received != "[123456789 987654321]" {
+		t.Fatal(fmt.Errorf("received should be: [123456789 987654321] not %s", received))
+	}
+	for _, reason := range []error{numbers.ErrSign, numbers.ErrWrongLength, numbers.ErrMissingNewLine} {
+		if rejections.Count(reason) != 1 {
+			t.Fatal(fmt.Errorf("one %v rejection should have been counted", reason))
+		}
+	}
+}
+
+func TestSkipAndReplyInvalidInputPolicy(t *testing.T) {
+	controller := numbers.NewTCPController(numbers.InputPolicy{OnInvalid: numbers.SkipAndReply}, nil)
+
+	received, err, replies := runController(t, controller, "12345678a\n123456789\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received != "[123456789]" {
+		t.Fatal(fmt.Errorf("received should be: [123456789] not %s", received))
+	}
+	if replies != "ERR non digit at position 8\n" {
+		t.Fatal(fmt.Errorf("replies should be: %q not %q", "ERR non digit at position 8\n", replies))
+	}
+}
+
+func TestMaxInvalidDisconnects(t *testing.T) {
+	rejections := &numbers.Rejections{}
+	policy := numbers.InputPolicy{OnInvalid: numbers.Skip, MaxInvalid: 2, InvalidWindow: time.Minute}
+	controller := numbers.NewTCPController(policy, rejections)
+
+	received, err, _ := runController(t, controller, "+12345678\n123456789\n+12345678\n987654321\n")
+	if !errors.Is(err, numbers.ErrTooManyInvalidLines) {
+		t.Fatal(fmt.Errorf("error should be: %v not %v", numbers.ErrTooManyInvalidLines, err))
+	}
+	if received != "[123456789]" {
+		t.Fatal(fmt.Errorf("received should be: [123456789] not %s", received))
+	}
+	if rejections.Disconnected() != 1 {
+		t.Fatal(fmt.Errorf("disconnected should be: 1 not %d", rejections.Disconnected()))
+	}
+}
+
+func TestTerminateDisabled(t *testing.T) {
+	controller := numbers.NewTCPController(numbers.InputPolicy{Terminate: numbers.TerminateDisabled}, nil)
+
+	received, err, _ := runController(t, controller, "123456789\nterminate\n")
+	if !errors.Is(err, numbers.ErrTerminateRefused) {
+		t.Fatal(fmt.Errorf("error should be: %v not %v", numbers.ErrTerminateRefused, err))
+	}
+	if received != "[123456789]" {
+		t.Fatal(fmt.Errorf("received should be: [123456789] not %s", received))
+	}
+}
+
+func TestTerminateWithToken(t *testing.T) {
+	policy := numbers.InputPolicy{Terminate: numbers.TerminateWithToken, TerminateToken: "secret"}
+	controller := numbers.NewTCPController(policy, nil)
+
+	for _, line := range []string{"terminate\n", "terminate guess\n"} {
+		if _, err, _ := runController(t, controller, line); !errors.Is(err, numbers.ErrTerminateRefused) {
+			t.Fatal(fmt.Errorf("error for %q should be: %v not %v", line, numbers.ErrTerminateRefused, err))
+		}
+	}
+	if _, err, _ := runController(t, controller, "terminate secret\n"); err != numbers.TERMINATED {
+		t.Fatal(fmt.Errorf("error should be: %v not %v", numbers.TERMINATED, err))
+	}
+}
+
+func TestTerminateTokenRejectedWithoutTokenPolicy(t *testing.T) {
+	for _, terminate := range []numbers.TerminatePolicy{numbers.TerminateAllowed, numbers.TerminateDisabled} {
+		controller := numbers.NewTCPController(numbers.InputPolicy{Terminate: terminate}, nil)
+		_, err, _ := runController(t, controller, "terminate secret\n")
+		if !errors.Is(err, numbers.ErrWrongLength) {
+			t.Fatal(fmt.Errorf("error for terminate %v should be: %v not %v", terminate, numbers.ErrWrongLength, err))
+		}
+	}
+}
+
+func TestParseInvalidInputPolicy(t *testing.T) {
+	for _, policy := range []numbers.InvalidInputPolicy{numbers.Disconnect, numbers.Skip, numbers.SkipAndReply} {
+		parsed, err := numbers.ParseInvalidInputPolicy(policy.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != policy {
+			t.Fatal(fmt.Errorf("parsed should be: %v not %v", policy, parsed))
+		}
+	}
+	if _, err := numbers.ParseInvalidInputPolicy("ignore"); err == nil {
+		t.Fatal("unknown policy error was expected")
+	}
+}
+
+// runController sends wire to the controller over a loopback connection.
+// Returns the numbers it received, its error and what it replied.
+func runController(t *testing.T, controller numbers.TCPController, wire string) (string, error, string) {
+	numbersOut := make(chan *numbers.Batch, 16)
+	err, replies := serveController(t, controller, wire, numbersOut, make(chan int))
+	close(numbersOut)
+
+	var received []int
+	for batch := range numbersOut {
+		received = append(received, batch.Numbers...)
+		batch.Release()
+	}
+	return fmt.Sprint(received), err, replies
+}
+
+// serveController sends wire to the controller over a loopback connection, the controller sends the numbers to out.
+// Returns its error and what it replied.
+func serveController(t *testing.T, controller numbers.TCPController, wire string,
+	out chan *numbers.Batch, terminate chan int) (error, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	replies := make(chan string, 1)
+	go func() {
+		client, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Error(err)
+			replies <- ""
+			return
+		}
+		defer client.Close()
+		// the controller may disconnect before reading all the wire, what it replied is what matters.
+		client.Write([]byte(wire))
+		client.(*net.TCPConn).CloseWrite()
+		reply, _ := ioutil.ReadAll(bufio.NewReader(client))
+		replies <- string(reply)
+	}()
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = controller(context.Background(), server, out, terminate)
+	server.Close()
+	return err, <-replies
+}
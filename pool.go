@@ -0,0 +1,315 @@
+package numbers
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultQueueTimeout is how long a connection over capacity waits for a worker when none is configured.
+const defaultQueueTimeout = 10 * time.Second
+
+// defaultQueueLength is how many connections over capacity wait for a worker at most when none is configured.
+const defaultQueueLength = 1024
+
+// CapacityPolicy says what the accept loop does with a connection when every worker is busy.
+type CapacityPolicy int
+
+const (
+	// QueueConnections makes the connection wait for a worker up to the queue timeout, then it is rejected.
+	QueueConnections CapacityPolicy = iota
+	// RejectConnections rejects the connection right away.
+	RejectConnections
+)
+
+var capacityPolicyNames = []string{"queue", "reject"}
+
+func (p CapacityPolicy) String() string {
+	if p < 0 || int(p) >= len(capacityPolicyNames) {
+		return fmt.Sprintf("CapacityPolicy(%d)", int(p))
+	}
+	return capacityPolicyNames[p]
+}
+
+// ParseCapacityPolicy returns the policy with the given name: queue or reject.
+func ParseCapacityPolicy(name string) (CapacityPolicy, error) {
+	for policy, policyName := range capacityPolicyNames {
+		if name == policyName {
+			return CapacityPolicy(policy), nil
+		}
+	}
+	return QueueConnections, fmt.Errorf("unknown capacity policy %s, should be one of queue or reject", name)
+}
+
+// Capacity configures what happens to the connections over the concurrency limit.
+type Capacity struct {
+	Policy CapacityPolicy
+	// QueueTimeout is how long a queued connection waits for a worker, 10s when 0.
+	QueueTimeout time.Duration
+	// QueueLength is how many connections wait for a worker at most, 1024 when 0. The ones over it are rejected.
+	QueueLength int
+}
+
+// ErrBusy is the reply to a connection rejected because every worker is busy, it is closed after it.
+var ErrBusy = errors.New("busy")
+
+var replyBusy = []byte(fmt.Sprintf("ERR %v\n", ErrBusy))
+
+// NewWorkerPoolListener returns a ConnectionListener with one accept loop handing the connections to workers,
+// every worker serves a connection at a time with the controller and sends its numbers to its own channel.
+// The connections over workers are handled as capacity says. A client terminating the server calls terminate.
+// The channels are closed once the listener is closed and the connections in flight are served.
+// Returns an error if workers is not between 1 and 65536.
+func NewWorkerPoolListener(workers int, controller TCPControllerV2, capacity Capacity,
+	terminate context.CancelFunc) (ConnectionListener, []chan *Batch, error) {
+	if err := checkConcurrentConnections(workers); err != nil {
+		return nil, nil, err
+	}
+	pool, numbersOuts := newWorkerPool(workers, controller, capacity, terminate, nil, newStatus(nil), standardLogger)
+	return pool.listen, numbersOuts, nil
+}
+
+// maxConcurrentConnections is the most workers a pool can have.
+const maxConcurrentConnections = 65536
+
+// checkConcurrentConnections returns an error if a pool cannot have n workers.
+func checkConcurrentConnections(n int) error {
+	if n <= 0 || n > maxConcurrentConnections {
+		return fmt.Errorf("concurrent connections should be between 1 and %d, not %d", maxConcurrentConnections, n)
+	}
+	return nil
+}
+
+// workerPool serves the connections of its accept loop with a number of workers that can be resized.
+type workerPool struct {
+	controller TCPControllerV2
+	terminate  func()
+	capacity   Capacity
+	// slots has a token per worker, a connection takes one from the time it is accepted until it is served.
+	slots chan struct{}
+	// conns hands the connections to the workers, retire makes an idle worker stop.
+	conns  chan net.Conn
+	retire chan struct{}
+	// waiting are the connections queued for a worker.
+	waiting int64
+	// joins sends the channels of the workers started by resize to the NumberStore, resize is not supported when nil.
+	joins   chan<- chan *Batch
+	outs    []chan *Batch
+	status  *status
+	logger  *log.Logger
+	started sync.Once
+	// mux guards ctx, the one of the accept loop, and size, the workers once the retiring ones stop.
+	mux  sync.Mutex
+	ctx  context.Context
+	size int
+	// closed is closed once the accept loop is done, no worker is started after.
+	closed chan int
+}
+
+func newWorkerPool(workers int, controller TCPControllerV2, capacity Capacity, terminate func(),
+	joins chan<- chan *Batch, status *status, logger *log.Logger) (*workerPool, []chan *Batch) {
+	if capacity.QueueTimeout <= 0 {
+		capacity.QueueTimeout = defaultQueueTimeout
+	}
+	if capacity.QueueLength <= 0 {
+		capacity.QueueLength = defaultQueueLength
+	}
+	pool := &workerPool{
+		controller: controller,
+		terminate:  terminate,
+		capacity:   capacity,
+		slots:      make(chan struct{}, maxConcurrentConnections),
+		conns:      make(chan net.Conn),
+		retire:     make(chan struct{}),
+		joins:      joins,
+		outs:       make([]chan *Batch, workers),
+		status:     status,
+		logger:     logger,
+		size:       workers,
+		closed:     make(chan int),
+	}
+	for i := range pool.outs {
+		pool.outs[i] = make(chan *Batch)
+		pool.slots <- struct{}{}
+	}
+	atomic.StoreInt64(&status.limit, int64(workers))
+	return pool, pool.outs
+}
+
+// listen starts the workers and accepts the connections of l until it is closed or ctx is canceled,
+// temporary errors accepting are retried.
+func (p *workerPool) listen(ctx context.Context, l net.Listener) {
+	p.started.Do(func() {
+		p.mux.Lock()
+		p.ctx = ctx
+		p.mux.Unlock()
+		for i, out := range p.outs {
+			p.logger.Printf("creating connection handler: %d", i)
+			go p.work(ctx, out)
+		}
+	})
+	var queued sync.WaitGroup
+	defer func() {
+		queued.Wait()
+		p.mux.Lock()
+		close(p.closed)
+		close(p.conns)
+		p.mux.Unlock()
+	}()
+	var delay time.Duration
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if !isTemporary(err) {
+				p.logger.Printf("%v", errors.Wrap(err, "accept connection"))
+				return
+			}
+			delay = acceptBackoff(delay)
+			p.logger.Printf("%v, retrying in %v", errors.Wrap(err, "accept connection"), delay)
+			select {
+			case <-time.After(delay):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		delay = 0
+		select {
+		case <-p.slots:
+			p.conns <- c
+			continue
+		default:
+		}
+		if p.capacity.Policy == RejectConnections {
+			p.reject(c)
+			continue
+		}
+		if atomic.AddInt64(&p.waiting, 1) > int64(p.capacity.QueueLength) {
+			atomic.AddInt64(&p.waiting, -1)
+			p.reject(c)
+			continue
+		}
+		atomic.AddInt64(&p.status.queued, 1)
+		queued.Add(1)
+		go func() {
+			defer queued.Done()
+			defer atomic.AddInt64(&p.waiting, -1)
+			p.queue(ctx, c)
+		}()
+	}
+}
+
+// maxAcceptDelay is the longest wait to accept again after a temporary error.
+const maxAcceptDelay = time.Second
+
+// acceptBackoff returns how long to wait to accept again after a temporary error, the last delay doubled
+// from 5ms up to maxAcceptDelay.
+func acceptBackoff(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return 5 * time.Millisecond
+	}
+	if delay *= 2; delay > maxAcceptDelay {
+		return maxAcceptDelay
+	}
+	return delay
+}
+
+// isTemporary returns if err is a temporary net error, like running out of file descriptors,
+// so accepting can be retried.
+func isTemporary(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Temporary()
+}
+
+// queue waits for a worker to take the connection, it is rejected once the queue timeout expires.
+func (p *workerPool) queue(ctx context.Context, c net.Conn) {
+	timeout := time.NewTimer(p.capacity.QueueTimeout)
+	defer timeout.Stop()
+	select {
+	case <-p.slots:
+		p.conns <- c
+	case <-timeout.C:
+		p.reject(c)
+	case <-ctx.Done():
+		closeConnection(c, p.logger)
+	}
+}
+
+// reject replies ErrBusy and closes the connection.
+func (p *workerPool) reject(c net.Conn) {
+	atomic.AddInt64(&p.status.rejected, 1)
+	defer closeConnection(c, p.logger)
+	if err := c.SetWriteDeadline(time.Now().Add(time.Second)); err != nil {
+		return
+	}
+	if _, err := c.Write(replyBusy); err != nil {
+		p.logger.Printf("%v", errors.Wrap(err, "reply busy"))
+	}
+}
+
+// work serves the connections handed to the worker, one at a time, until there are no more or it is retired.
+func (p *workerPool) work(ctx context.Context, numbers chan *Batch) {
+	defer close(numbers)
+	for {
+		select {
+		case c, more := <-p.conns:
+			if !more {
+				return
+			}
+			serveConnection(ctx, c, p.controller, numbers, p.terminate, p.logger)
+			p.slots <- struct{}{}
+		case <-p.retire:
+			return
+		}
+	}
+}
+
+// resize starts or retires workers until there are n. The new workers join the NumberStore before serving,
+// a busy worker is only retired once a connection is served, so no connection in flight is cut.
+func (p *workerPool) resize(n int) error {
+	if err := checkConcurrentConnections(n); err != nil {
+		return err
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.joins == nil {
+		return errors.New("resize not supported")
+	}
+	if p.ctx == nil || isClosed(p.closed) {
+		return errors.New("not accepting connections")
+	}
+	p.logger.Printf("concurrent connections resized from %d to %d", p.size, n)
+	for ; p.size < n; p.size++ {
+		out := make(chan *Batch)
+		p.joins <- out
+		p.logger.Printf("creating connection handler: %d", p.size)
+		go p.work(p.ctx, out)
+		p.slots <- struct{}{}
+	}
+	for ; p.size > n; p.size-- {
+		go p.retireWorker()
+	}
+	atomic.StoreInt64(&p.status.limit, int64(n))
+	return nil
+}
+
+// retireWorker takes a slot, once one is free, so a worker is idle and can be retired.
+func (p *workerPool) retireWorker() {
+	select {
+	case <-p.slots:
+	case <-p.closed:
+		return
+	}
+	select {
+	case p.retire <- struct{}{}:
+	case <-p.closed:
+	}
+}
@@ -0,0 +1,180 @@
+package numbers_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"tgracchus/numbers"
+	"time"
+)
+
+// startWorkerPool serves a worker pool of one worker at an ephemeral port and returns a client served by the worker.
+func startWorkerPool(t *testing.T, capacity numbers.Capacity) (net.Listener, net.Conn, chan *numbers.Batch) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	cnnListener, outs, err := numbers.NewWorkerPoolListener(1, numbers.DefaultTCPControllerV2, capacity, cancel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go cnnListener(ctx, l)
+	busy, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendData(t, busy, "000000001")
+	expectNumber(outs[0], 1, t)
+	return l, busy, outs[0]
+}
+
+// expectBusy expects the client to be replied ErrBusy and disconnected.
+func expectBusy(t *testing.T, address net.Addr) {
+	client, err := net.Dial("tcp", address.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "ERR busy\n" {
+		t.Fatal(fmt.Errorf("reply should be: %q not %q", "ERR busy\n", reply))
+	}
+}
+
+func TestNewWorkerPoolListenerErrors(t *testing.T) {
+	for _, workers := range []int{0, 65537} {
+		_, _, err := numbers.NewWorkerPoolListener(workers, numbers.DefaultTCPControllerV2, numbers.Capacity{},
+			func() {})
+		if err == nil {
+			t.Fatal(fmt.Errorf("a pool of %d workers should not be created", workers))
+		}
+	}
+}
+
+func TestWorkerPoolRejectsOverCapacity(t *testing.T) {
+	l, busy, out := startWorkerPool(t, numbers.Capacity{Policy: numbers.RejectConnections})
+	expectBusy(t, l.Addr())
+	l.Close()
+	busy.Close()
+	expectClosed(t, out)
+}
+
+func TestWorkerPoolQueuesOverCapacity(t *testing.T) {
+	l, busy, out := startWorkerPool(t, numbers.Capacity{QueueTimeout: 5 * time.Second})
+	defer l.Close()
+	queued, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queued.Close()
+	sendData(t, queued, "000000002")
+	busy.Close()
+	expectNumber(out, 2, t)
+}
+
+func TestWorkerPoolRejectsOverQueueLength(t *testing.T) {
+	l, busy, out := startWorkerPool(t, numbers.Capacity{QueueTimeout: 5 * time.Second, QueueLength: 1})
+	defer l.Close()
+	queued, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queued.Close()
+	expectBusy(t, l.Addr())
+	sendData(t, queued, "000000002")
+	busy.Close()
+	expectNumber(out, 2, t)
+}
+
+func TestWorkerPoolQueueTimeout(t *testing.T) {
+	l, busy, _ := startWorkerPool(t, numbers.Capacity{QueueTimeout: 50 * time.Millisecond})
+	defer l.Close()
+	defer busy.Close()
+	expectBusy(t, l.Addr())
+}
+
+func TestServerCountsConnectionsOverCapacity(t *testing.T) {
+	server, _ := newTestServer(t, numbers.Options{
+		ConcurrentConnections: 1,
+		Capacity:              numbers.Capacity{QueueTimeout: 50 * time.Millisecond},
+		HTTPAddress:           "localhost:4115",
+		Ack:                   true,
+	})
+	if err := server.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	busy, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := busy.Write([]byte("000000001\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bufio.NewReader(busy).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	expectBusy(t, server.Addr())
+	stats := getStats(t, "http://localhost:4115/stats")
+	if stats.QueuedConnections != 1 || stats.RejectedConnections != 1 {
+		t.Fatal(fmt.Errorf("queued and rejected connections should be 1, not %d and %d",
+			stats.QueuedConnections, stats.RejectedConnections))
+	}
+	busy.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// temporaryError is a net.Error that is temporary, as running out of file descriptors is.
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyListener fails to accept with a temporary error the first failures times.
+type flakyListener struct {
+	net.Listener
+	failures int32
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if atomic.AddInt32(&l.failures, -1) >= 0 {
+		return nil, temporaryError{}
+	}
+	return l.Listener.Accept()
+}
+
+func TestWorkerPoolRetriesTemporaryAcceptErrors(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cnnListener, outs, err := numbers.NewWorkerPoolListener(1, numbers.DefaultTCPControllerV2, numbers.Capacity{}, cancel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go cnnListener(ctx, &flakyListener{Listener: l, failures: 3})
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	sendData(t, client, "000000001")
+	expectNumber(outs[0], 1, t)
+}
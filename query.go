@@ -0,0 +1,112 @@
+package numbers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// maxQueryLineLength is the longest query line accepted, it holds 1024 numbers.
+const maxQueryLineLength = 1024 * lineLength
+
+// ErrQueryLineTooLong is the reply to a query line over maxQueryLineLength, the client is disconnected.
+var ErrQueryLineTooLong = errors.New("query line too long")
+
+var (
+	replyYes = []byte("YES")
+	replyNo  = []byte("NO\n")
+)
+
+// startQueries serves the membership queries of the shards at address until stop is closed.
+// A query line has one or more 9 digit numbers separated by a space, every one of them is replied in order
+// with a line: YES, followed by when it was first seen if the shards track it, NO or ERR <reason>.
+// The shards are queried between the batches of the NumberStore, not behind the numbers waiting for it.
+func startQueries(address string, shards *Shards, stop chan int, logger *log.Logger) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return errors.Wrap(err, "query listener")
+	}
+	logger.Printf("query server started at:%s", address)
+	go func() {
+		<-stop
+		closeListener(l, logger)
+	}()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				logger.Printf("%v", errors.Wrap(err, "accept query connection"))
+				return
+			}
+			go func() {
+				defer closeConnection(c, logger)
+				if err := serveQueries(c, shards); err != nil {
+					logger.Printf("%v", err)
+				}
+			}()
+		}
+	}()
+	return nil
+}
+
+// serveQueries replies the query lines of the client until it disconnects, the replies are written
+// once all the lines already received are replied.
+func serveQueries(c net.Conn, shards *Shards) error {
+	reader := bufio.NewReaderSize(c, maxQueryLineLength)
+	writer := bufio.NewWriter(c)
+	for {
+		if reader.Buffered() == 0 {
+			if err := c.SetWriteDeadline(time.Now().Add(readDeadline)); err != nil {
+				return errors.Wrap(err, "SetWriteDeadline")
+			}
+			if err := writer.Flush(); err != nil {
+				return errors.Wrap(err, "write query replies")
+			}
+		}
+		if err := c.SetReadDeadline(time.Now().Add(readDeadline)); err != nil {
+			return errors.Wrap(err, "SetReadDeadline")
+		}
+		line, err := reader.ReadSlice('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if err == bufio.ErrBufferFull {
+			fmt.Fprintf(writer, "ERR %v\n", ErrQueryLineTooLong)
+			writer.Flush()
+			return fmt.Errorf("query client: %s, %w", c.RemoteAddr().String(), ErrQueryLineTooLong)
+		}
+		if err != nil && err != io.EOF {
+			return errors.Wrap(err, "read query line")
+		}
+		line = bytes.TrimSuffix(line, []byte{'\n'})
+		for _, field := range bytes.Split(line, []byte{' '}) {
+			replyQuery(writer, shards, field)
+		}
+		if err == io.EOF {
+			return errors.Wrap(writer.Flush(), "write query replies")
+		}
+	}
+}
+
+// replyQuery writes the reply to the query of a number.
+func replyQuery(writer *bufio.Writer, shards *Shards, field []byte) {
+	number, err := parseNumber(field)
+	switch {
+	case err != nil:
+		fmt.Fprintf(writer, "ERR %v\n", err)
+	case !shards.Contains(number):
+		writer.Write(replyNo)
+	default:
+		writer.Write(replyYes)
+		if seen, ok := shards.FirstSeen(number); ok {
+			writer.WriteByte(' ')
+			writer.WriteString(seen.UTC().Format(time.RFC3339Nano))
+		}
+		writer.WriteByte('\n')
+	}
+}
@@ -0,0 +1,101 @@
+package numbers_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"tgracchus/numbers"
+	"time"
+)
+
+func TestShardsContainsAndFirstSeen(t *testing.T) {
+	shards := numbers.NewShards(4, func(size int) numbers.Deduplicator { return numbers.NewMapSet() })
+	shards.TrackFirstSeen()
+	ins := []chan *numbers.Batch{make(chan *numbers.Batch)}
+	terminate := make(chan int)
+	out := numbers.ShardedNumberStore(1000, shards, ins, terminate)
+	before := time.Now()
+	batch := numbers.NewBatch()
+	batch.Numbers = append(batch.Numbers, 1, 999999999)
+	ins[0] <- batch
+	(<-out).Release()
+	(<-out).Release()
+
+	for _, number := range []int{1, 999999999} {
+		if !shards.Contains(number) {
+			t.Fatal(fmt.Errorf("number %d should be contained", number))
+		}
+		seen, ok := shards.FirstSeen(number)
+		if !ok || seen.Before(before) || seen.After(time.Now()) {
+			t.Fatal(fmt.Errorf("number %d should be first seen after %v, not %v", number, before, seen))
+		}
+	}
+	if shards.Contains(2) {
+		t.Fatal(fmt.Errorf("number %d should not be contained", 2))
+	}
+	if _, ok := shards.FirstSeen(2); ok {
+		t.Fatal(fmt.Errorf("number %d should not be seen", 2))
+	}
+	close(terminate)
+	close(ins[0])
+}
+
+func TestMembershipQueries(t *testing.T) {
+	stopped, conn := startTestServer(t, numbers.Options{
+		Address:        "localhost:4113",
+		QueryAddress:   "localhost:4114",
+		QueryFirstSeen: true,
+	})
+	defer conn.Close()
+	if _, err := conn.Write([]byte("000000001\n000000002\n000000001\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	query, err := net.Dial("tcp", "localhost:4114")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer query.Close()
+	replies := bufio.NewReader(query)
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("number 2 should be stored")
+		}
+		if _, err := query.Write([]byte("000000002\n")); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := replies.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(reply, "YES ") {
+			break
+		}
+	}
+
+	if _, err := query.Write([]byte("000000001 000000003\n12345678a\n000000002\n")); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"YES ", "NO\n", "ERR non digit at position 8\n", "YES "}
+	for _, prefix := range expected {
+		reply, err := replies.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(reply, prefix) {
+			t.Fatal(fmt.Errorf("reply should start with: %q not %q", prefix, reply))
+		}
+		if prefix == "YES " {
+			if _, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(reply[len(prefix):])); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if _, err := conn.Write([]byte("terminate\n")); err != nil {
+		t.Fatal(err)
+	}
+	<-stopped
+}
@@ -0,0 +1,165 @@
+package numbers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"log"
+	"strconv"
+	"time"
+)
+
+// defaultReportPeriod is the time between reports when none is configured.
+const defaultReportPeriod = 10 * time.Second
+
+// Report is what a NumberStore reports at the end of every report window.
+type Report struct {
+	Time time.Time
+	// WindowUnique and WindowDuplicates are the numbers received in the window.
+	WindowUnique     int64
+	WindowDuplicates int64
+	// UniqueTotal and Total are the numbers received since the server first started.
+	UniqueTotal int64
+	Total       int64
+	// Window is how long the window lasted.
+	Window time.Duration
+	// Final is set for the report of a NumberStore once all its numbers are stored.
+	Final bool
+	// WindowRejected are the lines rejected in the window by reason, and the clients disconnected for too many
+	// of them since the server started or the last reset.
+	WindowRejected RejectedStats
+	// InputPolicy describes the policies the lines are rejected with, it is empty when they are not counted.
+	InputPolicy string
+}
+
+// Reporter receives the reports of a NumberStore, one at a time and in order.
+type Reporter interface {
+	Report(report Report) error
+}
+
+// reportAll sends the report to every one of the reporters, an error of one of them is logged
+// and does not stop the others.
+func reportAll(reporters []Reporter, report Report, logger *log.Logger) {
+	for _, reporter := range reporters {
+		if err := reporter.Report(report); err != nil {
+			logger.Printf("%v", errors.Wrap(err, "report"))
+		}
+	}
+}
+
+// LogReporter logs every report in a line.
+type LogReporter struct {
+	logger *log.Logger
+}
+
+// NewLogReporter returns a LogReporter logging to the standard logger.
+func NewLogReporter() *LogReporter {
+	return &LogReporter{logger: standardLogger}
+}
+
+// NewLoggerReporter returns a LogReporter logging to logger.
+func NewLoggerReporter(logger *log.Logger) *LogReporter {
+	return &LogReporter{logger: logger}
+}
+
+// Report logs the report.
+func (r *LogReporter) Report(report Report) error {
+	name := "Report"
+	if report.Final {
+		name = "Final report"
+	}
+	r.logger.Printf("%s %v Received %d unique numbers, %d duplicates. Unique total: %d. Total: %d",
+		name, report.Time, report.WindowUnique, report.WindowDuplicates, report.UniqueTotal, report.Total)
+	if report.InputPolicy != "" {
+		rejected := report.WindowRejected
+		r.logger.Printf("%s %v Rejected lines, %d wrong length, %d non digit, %d sign, %d missing new line. "+
+			"Invalid input policy: %s. Disconnected for too many: %d", name, report.Time, rejected.WrongLength,
+			rejected.NonDigit, rejected.Sign, rejected.MissingNewLine, report.InputPolicy, rejected.Disconnected)
+	}
+	return nil
+}
+
+// jsonReport is how a Report is written as JSON.
+type jsonReport struct {
+	Time             time.Time     `json:"time"`
+	WindowUnique     int64         `json:"window_unique"`
+	WindowDuplicates int64         `json:"window_duplicates"`
+	UniqueTotal      int64         `json:"unique_total"`
+	Total            int64         `json:"total"`
+	WindowSeconds    float64       `json:"window_seconds"`
+	Final            bool          `json:"final"`
+	WindowRejected   RejectedStats `json:"window_rejected"`
+	InputPolicy      string        `json:"input_policy"`
+}
+
+// JSONReporter writes every report as a line of JSON.
+type JSONReporter struct {
+	encoder *json.Encoder
+}
+
+// NewJSONReporter returns a JSONReporter writing to w.
+func NewJSONReporter(w io.Writer) *JSONReporter {
+	return &JSONReporter{encoder: json.NewEncoder(w)}
+}
+
+// Report writes the report as a line of JSON.
+func (r *JSONReporter) Report(report Report) error {
+	return errors.Wrap(r.encoder.Encode(jsonReport{
+		Time:             report.Time,
+		WindowUnique:     report.WindowUnique,
+		WindowDuplicates: report.WindowDuplicates,
+		UniqueTotal:      report.UniqueTotal,
+		Total:            report.Total,
+		WindowSeconds:    report.Window.Seconds(),
+		Final:            report.Final,
+		WindowRejected:   report.WindowRejected,
+		InputPolicy:      report.InputPolicy,
+	}), "write json report")
+}
+
+// csvHeader are the columns of the reports written by a CSVReporter.
+var csvHeader = []string{"time", "window_unique", "window_duplicates", "unique_total", "total", "window_seconds", "final",
+	"rejected_wrong_length", "rejected_non_digit", "rejected_sign", "rejected_missing_new_line", "disconnected",
+	"input_policy"}
+
+// CSVReporter writes every report as a CSV record, after a header record.
+type CSVReporter struct {
+	writer *csv.Writer
+	header bool
+}
+
+// NewCSVReporter returns a CSVReporter writing to w, it writes the header with the first report.
+func NewCSVReporter(w io.Writer) *CSVReporter {
+	return &CSVReporter{writer: csv.NewWriter(w), header: true}
+}
+
+// Report writes the report as a CSV record.
+func (r *CSVReporter) Report(report Report) error {
+	if r.header {
+		if err := r.writer.Write(csvHeader); err != nil {
+			return errors.Wrap(err, "write csv header")
+		}
+		r.header = false
+	}
+	err := r.writer.Write([]string{
+		report.Time.Format(time.RFC3339Nano),
+		strconv.FormatInt(report.WindowUnique, 10),
+		strconv.FormatInt(report.WindowDuplicates, 10),
+		strconv.FormatInt(report.UniqueTotal, 10),
+		strconv.FormatInt(report.Total, 10),
+		strconv.FormatFloat(report.Window.Seconds(), 'f', -1, 64),
+		strconv.FormatBool(report.Final),
+		strconv.FormatInt(report.WindowRejected.WrongLength, 10),
+		strconv.FormatInt(report.WindowRejected.NonDigit, 10),
+		strconv.FormatInt(report.WindowRejected.Sign, 10),
+		strconv.FormatInt(report.WindowRejected.MissingNewLine, 10),
+		strconv.FormatInt(report.WindowRejected.Disconnected, 10),
+		report.InputPolicy,
+	})
+	if err != nil {
+		return errors.Wrap(err, "write csv report")
+	}
+	r.writer.Flush()
+	return errors.Wrap(r.writer.Error(), "write csv report")
+}
@@ -0,0 +1,125 @@
+package numbers_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"testing"
+	"tgracchus/numbers"
+	"time"
+)
+
+var testReport = numbers.Report{
+	Time:             time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	WindowUnique:     2,
+	WindowDuplicates: 1,
+	UniqueTotal:      5,
+	Total:            8,
+	Window:           1500 * time.Millisecond,
+	WindowRejected:   numbers.RejectedStats{WrongLength: 3, Sign: 1, Disconnected: 1},
+	InputPolicy:      "disconnect",
+}
+
+func TestJSONReporter(t *testing.T) {
+	var b bytes.Buffer
+	reporter := numbers.NewJSONReporter(&b)
+	final := testReport
+	final.Final = true
+	for _, report := range []numbers.Report{testReport, final} {
+		if err := reporter.Report(report); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rejected := `"window_rejected":{"wrong_length":3,"non_digit":0,"sign":1,"missing_new_line":0,"disconnected":1},` +
+		`"input_policy":"disconnect"}`
+	expected := `{"time":"2020-01-02T03:04:05Z","window_unique":2,"window_duplicates":1,"unique_total":5,"total":8,"window_seconds":1.5,"final":false,` +
+		rejected + "\n" +
+		`{"time":"2020-01-02T03:04:05Z","window_unique":2,"window_duplicates":1,"unique_total":5,"total":8,"window_seconds":1.5,"final":true,` +
+		rejected + "\n"
+	if b.String() != expected {
+		t.Fatal(fmt.Errorf("json reports should be: %s not %s", expected, b.String()))
+	}
+}
+
+func TestCSVReporter(t *testing.T) {
+	var b bytes.Buffer
+	reporter := numbers.NewCSVReporter(&b)
+	for i := 0; i < 2; i++ {
+		if err := reporter.Report(testReport); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := "time,window_unique,window_duplicates,unique_total,total,window_seconds,final," +
+		"rejected_wrong_length,rejected_non_digit,rejected_sign,rejected_missing_new_line,disconnected,input_policy\n" +
+		"2020-01-02T03:04:05Z,2,1,5,8,1.5,false,3,0,1,0,1,disconnect\n" +
+		"2020-01-02T03:04:05Z,2,1,5,8,1.5,false,3,0,1,0,1,disconnect\n"
+	if b.String() != expected {
+		t.Fatal(fmt.Errorf("csv reports should be: %s not %s", expected, b.String()))
+	}
+}
+
+func TestLogReporter(t *testing.T) {
+	var b bytes.Buffer
+	reporter := numbers.NewLoggerReporter(log.New(&b, "", 0))
+	if err := reporter.Report(testReport); err != nil {
+		t.Fatal(err)
+	}
+	expected := "Report 2020-01-02 03:04:05 +0000 UTC Received 2 unique numbers, 1 duplicates. Unique total: 5. Total: 8\n" +
+		"Report 2020-01-02 03:04:05 +0000 UTC Rejected lines, 3 wrong length, 0 non digit, 1 sign, 0 missing new line. " +
+		"Invalid input policy: disconnect. Disconnected for too many: 1\n"
+	if b.String() != expected {
+		t.Fatal(fmt.Errorf("log reports should be: %s not %s", expected, b.String()))
+	}
+}
+
+// chanReporter sends every report to its channel.
+type chanReporter chan numbers.Report
+
+func (r chanReporter) Report(report numbers.Report) error {
+	r <- report
+	return nil
+}
+
+func TestServerSendsReportsToEveryReporter(t *testing.T) {
+	first, second := make(chanReporter, 100), make(chanReporter, 100)
+	stopped, conn := startTestServer(t, numbers.Options{
+		Address:      "localhost:4110",
+		ReportPeriod: 20 * time.Millisecond,
+		Reporters:    []numbers.Reporter{first, second},
+	})
+	defer conn.Close()
+	if _, err := conn.Write([]byte("000000001\n000000002\n000000001\n")); err != nil {
+		t.Fatal(err)
+	}
+	var total numbers.Report
+	for timeout := time.After(5 * time.Second); total.Total < 3; {
+		select {
+		case report := <-first:
+			if report.Window <= 0 || report.Final {
+				t.Fatal(fmt.Errorf("report should be of a window, not final: %+v", report))
+			}
+			total.WindowUnique += report.WindowUnique
+			total.WindowDuplicates += report.WindowDuplicates
+			total.Total = report.Total
+		case <-timeout:
+			t.Fatal(fmt.Errorf("total should be: 3 not %d", total.Total))
+		}
+	}
+	if total.WindowUnique != 2 || total.WindowDuplicates != 1 {
+		t.Fatal(fmt.Errorf("windows should add up to 2 unique and 1 duplicate, not %+v", total))
+	}
+
+	if _, err := conn.Write([]byte("terminate\n")); err != nil {
+		t.Fatal(err)
+	}
+	<-stopped
+	for _, reporter := range []chanReporter{first, second} {
+		var last numbers.Report
+		for len(reporter) > 0 {
+			last = <-reporter
+		}
+		if !last.Final || last.Total != 3 || last.UniqueTotal != 2 {
+			t.Fatal(fmt.Errorf("last report should be final with 3 numbers, 2 unique, not %+v", last))
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package numbers
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"log"
+	"os"
+)
+
+// lineLength is the length of a number line in numbers.log, 9 digits and a new line.
+const lineLength = 10
+
+// ResumeNumberLog streams the numbers of the number log at filePath into numbers, validating every line.
+// Corrupt or partial lines at the end of the log are truncated and reported, a corrupt line followed
+// by valid ones is an error as it can not be fixed without losing numbers.
+// Returns how many numbers were loaded, a missing log loads none.
+func ResumeNumberLog(filePath string, numbers Deduplicator) (int, error) {
+	return ResumeNumberLogFrom(filePath, 0, numbers)
+}
+
+// ResumeNumberLogFrom works as ResumeNumberLog but skips the numbers before offset,
+// which must be at the start of a line.
+func ResumeNumberLogFrom(filePath string, offset int64, numbers Deduplicator) (int, error) {
+	return resumeNumberLog(filePath, offset, numbers, standardLogger)
+}
+
+func resumeNumberLog(filePath string, offset int64, numbers Deduplicator, logger *log.Logger) (int, error) {
+	f, err := os.OpenFile(filePath, os.O_RDWR, 0)
+	if os.IsNotExist(err) && offset == 0 {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "open number log")
+	}
+	defer closeLog(f, logger)
+
+	if offset%lineLength != 0 {
+		return 0, fmt.Errorf("offset %d is not at the start of a line", offset)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, errors.Wrap(err, "seek number log")
+	}
+	loaded, validSize, err := replayNumberLog(bufio.NewReader(f), offset, numbers)
+	if err != nil {
+		return loaded, errors.Wrap(err, filePath)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return loaded, errors.Wrap(err, "stat number log")
+	}
+	if info.Size() > validSize {
+		if err := f.Truncate(validSize); err != nil {
+			return loaded, errors.Wrap(err, "truncate number log")
+		}
+		logger.Printf("truncated %d corrupt bytes at the end of %s, from offset %d",
+			info.Size()-validSize, filePath, validSize)
+	}
+	return loaded, nil
+}
+
+// replayNumberLog adds every number of the log, read from offset, to numbers until the first corrupt line.
+// Returns how many numbers were loaded and the size of the log up to the first corrupt line.
+func replayNumberLog(reader *bufio.Reader, offset int64, numbers Deduplicator) (int, int64, error) {
+	loaded := 0
+	var corruptOffset int64 = -1
+	longLine := false
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			longLine = true
+		} else if err != nil && err != io.EOF {
+			return loaded, offset, errors.Wrap(err, "ReadSlice")
+		}
+		if len(line) == 0 {
+			break
+		}
+
+		valid := !longLine && validLine(line)
+		if err != bufio.ErrBufferFull {
+			longLine = false
+		}
+		if !valid {
+			if corruptOffset < 0 {
+				corruptOffset = offset
+			}
+		} else if corruptOffset >= 0 {
+			return loaded, corruptOffset, fmt.Errorf("corrupt line at offset %d followed by valid lines", corruptOffset)
+		} else {
+			numbers.TestAndSet(parseDigits(line[:lineLength-1]))
+			loaded++
+		}
+		offset += int64(len(line))
+	}
+	if corruptOffset >= 0 {
+		return loaded, corruptOffset, nil
+	}
+	return loaded, offset, nil
+}
+
+func validLine(line []byte) bool {
+	if len(line) != lineLength || line[lineLength-1] != '\n' {
+		return false
+	}
+	for _, digit := range line[:lineLength-1] {
+		if digit < '0' || digit > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func parseDigits(digits []byte) int {
+	number := 0
+	for _, digit := range digits {
+		number = number*10 + int(digit-'0')
+	}
+	return number
+}
+
+// closeLog closes f, logging the error closing it, if any, to logger.
+func closeLog(f *os.File, logger *log.Logger) {
+	if err := f.Close(); err != nil {
+		logger.Printf("%v", errors.Wrap(err, "closing number log"))
+	}
+}
@@ -0,0 +1,111 @@
+package numbers_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"tgracchus/numbers"
+	"time"
+)
+
+func TestResumeNumberLog(t *testing.T) {
+	filePath := writeTestLog(t, "000000001\n123456789\n000000001\n")
+	defer cleanUpFile(filePath)
+
+	dedup := numbers.NewBitSet(maxNumbers)
+	loaded, err := numbers.ResumeNumberLog(filePath, dedup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 3 {
+		t.Fatal(fmt.Errorf("loaded should be: %d not %d", 3, loaded))
+	}
+	if dedup.Len() != 2 || !dedup.TestAndSet(1) || !dedup.TestAndSet(123456789) {
+		t.Fatal("numbers 1 and 123456789 should have been loaded")
+	}
+}
+
+func TestResumeNumberLogMissingFile(t *testing.T) {
+	loaded, err := numbers.ResumeNumberLog(testFilePath(os.TempDir()), numbers.NewMapSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 0 {
+		t.Fatal(fmt.Errorf("loaded should be: %d not %d", 0, loaded))
+	}
+}
+
+func TestResumeNumberLogTruncatesTrailingCorruptLines(t *testing.T) {
+	filePath := writeTestLog(t, "123456789\n12345\n+12345678\n98765")
+	defer cleanUpFile(filePath)
+
+	loaded, err := numbers.ResumeNumberLog(filePath, numbers.NewMapSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 1 {
+		t.Fatal(fmt.Errorf("loaded should be: %d not %d", 1, loaded))
+	}
+	expectFileContent(t, filePath, "123456789\n")
+}
+
+func TestResumeNumberLogCorruptLineFollowedByValidLines(t *testing.T) {
+	content := "123456789\nIAMTEXT!!\n987654321\n"
+	filePath := writeTestLog(t, content)
+	defer cleanUpFile(filePath)
+
+	_, err := numbers.ResumeNumberLog(filePath, numbers.NewMapSet())
+	if err == nil {
+		t.Fatal("corrupt line error was expected")
+	}
+	expectFileContent(t, filePath, content)
+}
+
+func TestAppendFileWriter(t *testing.T) {
+	filePath := writeTestLog(t, "123456789\n")
+	defer cleanUpFile(filePath)
+
+	numbersIn := make(chan *numbers.Batch, 1)
+	numbersIn <- batchOf(987654321)
+	close(numbersIn)
+	done, err := numbers.AppendFileWriter(numbersIn, filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+		expectFileContent(t, filePath, "123456789\n987654321\n")
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout while waiting for the file writer to be done")
+	}
+}
+
+func TestAppendFileWriterOpenError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "numbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := numbers.AppendFileWriter(make(chan *numbers.Batch), dir); err == nil {
+		t.Fatal("a directory should not be opened as the number log")
+	}
+}
+
+func writeTestLog(t *testing.T, content string) string {
+	filePath := testFilePath(os.TempDir())
+	if err := ioutil.WriteFile(filePath, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func expectFileContent(t *testing.T, filePath string, expected string) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != expected {
+		t.Fatal(fmt.Errorf("file content should be: %q not %q", expected, string(content)))
+	}
+}
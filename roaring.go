@@ -0,0 +1,203 @@
+package numbers
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// arrayContainerMax is the cardinality from which a sorted array takes more memory than a bitmap.
+const arrayContainerMax = 4096
+
+// RoaringBitmap is a compressed set of the numbers in [0, 2^32) in the style of roaring bitmaps.
+// Numbers are grouped by their high 16 bits, every group keeps its low 16 bits in a sorted array while
+// it is sparse and switches to a 8KB bitmap when it gets dense.
+type RoaringBitmap struct {
+	containers []*roaringContainer
+	len        int
+}
+
+type roaringContainer struct {
+	array  []uint16
+	bitmap []uint64
+}
+
+// NewRoaringBitmap creates an empty RoaringBitmap.
+func NewRoaringBitmap() *RoaringBitmap {
+	return &RoaringBitmap{containers: make([]*roaringContainer, 1<<16)}
+}
+
+// TestAndSet adds the number to the set and returns if it was already there.
+// The number must be in [0, 2^32).
+func (r *RoaringBitmap) TestAndSet(number int) bool {
+	high := number >> 16
+	container := r.containers[high]
+	if container == nil {
+		container = &roaringContainer{}
+		r.containers[high] = container
+	}
+	if container.testAndSet(uint16(number)) {
+		return true
+	}
+	r.len++
+	return false
+}
+
+// Contains returns if the number is in the set, the number must be in [0, 2^32).
+func (r *RoaringBitmap) Contains(number int) bool {
+	container := r.containers[number>>16]
+	return container != nil && container.contains(uint16(number))
+}
+
+// Len returns how many numbers are in the set.
+func (r *RoaringBitmap) Len() int {
+	return r.len
+}
+
+func (c *roaringContainer) testAndSet(low uint16) bool {
+	if c.bitmap != nil {
+		word := &c.bitmap[low/wordSize]
+		mask := uint64(1) << (low % wordSize)
+		if *word&mask != 0 {
+			return true
+		}
+		*word |= mask
+		return false
+	}
+
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= low })
+	if i < len(c.array) && c.array[i] == low {
+		return true
+	}
+	c.array = append(c.array, 0)
+	copy(c.array[i+1:], c.array[i:])
+	c.array[i] = low
+	if len(c.array) > arrayContainerMax {
+		c.toBitmap()
+	}
+	return false
+}
+
+func (c *roaringContainer) contains(low uint16) bool {
+	if c.bitmap != nil {
+		return c.bitmap[low/wordSize]&(uint64(1)<<(low%wordSize)) != 0
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= low })
+	return i < len(c.array) && c.array[i] == low
+}
+
+func (c *roaringContainer) toBitmap() {
+	c.bitmap = make([]uint64, (1<<16)/wordSize)
+	for _, low := range c.array {
+		c.bitmap[low/wordSize] |= uint64(1) << (low % wordSize)
+	}
+	c.array = nil
+}
+
+// WriteTo writes the length of the set followed by its containers.
+// Every container is written as its high bits, its kind and either its array or its bitmap.
+func (r *RoaringBitmap) WriteTo(w io.Writer) (int64, error) {
+	containers := 0
+	for _, container := range r.containers {
+		if container != nil {
+			containers++
+		}
+	}
+	written, err := writeUint64s(w, uint64(r.len), uint64(containers))
+	if err != nil {
+		return written, err
+	}
+	for high, container := range r.containers {
+		if container == nil {
+			continue
+		}
+		var n int64
+		if container.bitmap != nil {
+			n, err = writeUint64s(w, uint64(high), bitmapContainer)
+			written += n
+			if err == nil {
+				n, err = writeWords(w, container.bitmap)
+			}
+		} else {
+			n, err = writeUint64s(w, uint64(high), arrayContainer, uint64(len(container.array)))
+			written += n
+			if err == nil {
+				n, err = writeWords(w, packArray(container.array))
+			}
+		}
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// ReadFrom reads the set written by WriteTo into an empty RoaringBitmap.
+func (r *RoaringBitmap) ReadFrom(reader io.Reader) (int64, error) {
+	var length, containers uint64
+	read, err := readUint64s(reader, &length, &containers)
+	if err != nil {
+		return read, err
+	}
+	for ; containers > 0; containers-- {
+		var high, kind uint64
+		n, err := readUint64s(reader, &high, &kind)
+		read += n
+		if err != nil {
+			return read, err
+		}
+		if high >= uint64(len(r.containers)) {
+			return read, fmt.Errorf("roaring container %d out of range", high)
+		}
+		container := &roaringContainer{}
+		switch kind {
+		case bitmapContainer:
+			container.bitmap = make([]uint64, (1<<16)/wordSize)
+			n, err = readWords(reader, container.bitmap)
+		case arrayContainer:
+			var size uint64
+			n, err = readUint64s(reader, &size)
+			if err == nil && size > arrayContainerMax {
+				err = fmt.Errorf("roaring array container too big, %d numbers", size)
+			}
+			if err == nil {
+				read += n
+				packed := make([]uint64, (size+3)/4)
+				n, err = readWords(reader, packed)
+				container.array = unpackArray(packed, int(size))
+			}
+		default:
+			err = fmt.Errorf("unknown roaring container kind %d", kind)
+		}
+		read += n
+		if err != nil {
+			return read, err
+		}
+		r.containers[high] = container
+	}
+	r.len = int(length)
+	return read, nil
+}
+
+const (
+	arrayContainer  = 1
+	bitmapContainer = 2
+)
+
+// packArray packs four uint16 per word.
+func packArray(array []uint16) []uint64 {
+	packed := make([]uint64, (len(array)+3)/4)
+	for i, low := range array {
+		packed[i/4] |= uint64(low) << (16 * uint(i%4))
+	}
+	return packed
+}
+
+func unpackArray(packed []uint64, size int) []uint16 {
+	array := make([]uint16, size)
+	for i := range array {
+		array[i] = uint16(packed[i/4] >> (16 * uint(i%4)))
+	}
+	return array
+}
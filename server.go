@@ -5,42 +5,108 @@ import (
 	"github.com/pkg/errors"
 	"log"
 	"net"
+	"sync"
+	"time"
 )
 
 // ConnectionListener given a listener it listen and establish connections.
 type ConnectionListener func(ctx context.Context, l net.Listener)
 
-type TCPController func(ctx context.Context, c net.Conn, numbers chan int, terminate chan int) error
+type TCPController func(ctx context.Context, c net.Conn, numbers chan *Batch, terminate chan int) error
+
+// TCPControllerV2 serves a connection sending its numbers to the numbers channel until the client is done or ctx
+// is canceled. It returns TERMINATED when its client terminates the server, or once ctx is canceled.
+type TCPControllerV2 func(ctx context.Context, c net.Conn, numbers chan *Batch) error
+
+// V2 returns a TCPControllerV2 running the controller, its terminate channel is closed once ctx is canceled.
+// It returns TERMINATED when the controller closes its terminate channel.
+func (controller TCPController) V2() TCPControllerV2 {
+	return func(ctx context.Context, c net.Conn, numbers chan *Batch) error {
+		terminate := make(chan int)
+		controlled := make(chan int)
+		go func() {
+			select {
+			case <-ctx.Done():
+				closeTerminate(terminate)
+			case <-controlled:
+			}
+		}()
+		err := controller(ctx, c, numbers, terminate)
+		close(controlled)
+		select {
+		case <-terminate:
+			if err == nil {
+				return TERMINATED
+			}
+		default:
+		}
+		return err
+	}
+}
+
+// v1 returns a TCPController running the controller until terminate is closed, a client terminating the server
+// closes it.
+func v1(controller TCPControllerV2) TCPController {
+	return func(ctx context.Context, c net.Conn, numbers chan *Batch, terminate chan int) error {
+		controllerCtx, cancel := contextUntilClosed(ctx, terminate)
+		defer cancel()
+		err := controller(controllerCtx, c, numbers)
+		if err == TERMINATED && ctx.Err() == nil {
+			closeTerminate(terminate)
+		}
+		if err == nil && isClosed(terminate) {
+			return TERMINATED
+		}
+		return err
+	}
+}
 
 // StartServer starts the server with the given connection listener and at the given address.
+// It serves until stop is closed or ctx is canceled, then the listener is closed.
 func StartServer(ctx context.Context, connectionListener ConnectionListener, address string, stop chan int) error {
-	conf := &net.ListenConfig{KeepAlive: 15}
-	l, err := conf.Listen(ctx, "tcp", address)
+	return StartServers(ctx, connectionListener, []string{address}, UnixSocket{}, stop)
+}
+
+// StartServers works as StartServer listening at every one of the addresses: host:port, [::]:port or unix:/path.sock.
+// The connection listener accepts the connections of all of them as a single listener, they are all closed together.
+// The unix sockets are created as socket says.
+func StartServers(ctx context.Context, connectionListener ConnectionListener, addresses []string, socket UnixSocket,
+	stop chan int) error {
+	l, err := listenAll(ctx, addresses, socket, standardLogger)
 	if err != nil {
-		log.Printf("%v", errors.Wrap(err, "Star listener"))
+		standardLogger.Printf("%v", err)
 		return err
 	}
-	defer closeListener(l)
-	log.Printf("server started at:%s", address)
+	defer closeListener(l, standardLogger)
+	for _, address := range l.addrs() {
+		standardLogger.Printf("server started at:%s", address.String())
+	}
 	go connectionListener(ctx, l)
-	<-stop
+	select {
+	case <-stop:
+	case <-ctx.Done():
+	}
 	return nil
 }
 
-func closeListener(l net.Listener) {
-	log.Printf("%v", "closing listener")
+func closeListener(l net.Listener, logger *log.Logger) {
+	logger.Printf("%v", "closing listener")
 	if err := l.Close(); err != nil {
-		log.Printf("%v", errors.Wrap(err, "Closing listener"))
+		logger.Printf("%v", errors.Wrap(err, "Closing listener"))
 	}
 }
 
 // NewMultipleConnectionListener starts has many instances as given in separate goroutines and waits the
 // context to be cancelled.
-func NewMultipleConnectionListener(listeners [] ConnectionListener) ConnectionListener {
+func NewMultipleConnectionListener(listeners []ConnectionListener) ConnectionListener {
+	return multipleConnectionListener(listeners, standardLogger)
+}
+
+func multipleConnectionListener(listeners []ConnectionListener, logger *log.Logger) ConnectionListener {
 	return func(ctx context.Context, l net.Listener) {
 		for i := 0; i < len(listeners); i++ {
 			go func(index int) {
-				log.Printf("creating connection handler: %d", index)
+				logger.Printf("creating connection handler: %d", index)
 				listeners[index](ctx, l)
 			}(i)
 		}
@@ -49,13 +115,34 @@ func NewMultipleConnectionListener(listeners [] ConnectionListener) ConnectionLi
 
 // NewSingleConnectionListener creates a new ConnectionListener which listen for a connection
 // and then it calls the given TCPController in a sync way.
-func NewSingleConnectionListener(controller TCPController, terminate chan int) (ConnectionListener, chan int) {
-	numbers := make(chan int)
+func NewSingleConnectionListener(controller TCPController, terminate chan int) (ConnectionListener, chan *Batch) {
+	listener, numbers := singleConnectionListener(controller.V2(), func() { closeTerminate(terminate) }, standardLogger)
+	return func(ctx context.Context, l net.Listener) {
+		ctx, cancel := contextUntilClosed(ctx, terminate)
+		defer cancel()
+		listener(ctx, l)
+	}, numbers
+}
+
+// NewSingleConnectionListenerV2 works as NewSingleConnectionListener, until ctx is canceled.
+// A client terminating the server calls terminate.
+func NewSingleConnectionListenerV2(controller TCPControllerV2, terminate context.CancelFunc) (ConnectionListener, chan *Batch) {
+	return singleConnectionListener(controller, terminate, standardLogger)
+}
+
+func singleConnectionListener(controller TCPControllerV2, terminate func(),
+	logger *log.Logger) (ConnectionListener, chan *Batch) {
+	numbers := make(chan *Batch)
 	return func(ctx context.Context, l net.Listener) {
 		defer close(numbers)
 		for {
-			if err := listenOnce(ctx, l, controller, numbers, terminate); err != nil {
-				log.Printf("%v", err)
+			if err := listenOnce(ctx, l, controller, numbers, terminate, logger); err != nil {
+				if ctx.Err() == nil {
+					logger.Printf("%v", err)
+				}
+				return
+			}
+			if ctx.Err() != nil {
 				return
 			}
 		}
@@ -64,27 +151,82 @@ func NewSingleConnectionListener(controller TCPController, terminate chan int) (
 
 var TERMINATED = errors.New("TERMINATED")
 
-func listenOnce(ctx context.Context, l net.Listener, controller TCPController, numbers chan int, terminate chan int) error {
+// listenOnce accepts a connection and serves it with the controller.
+func listenOnce(ctx context.Context, l net.Listener, controller TCPControllerV2, numbers chan *Batch,
+	terminate func(), logger *log.Logger) error {
 	c, err := l.Accept()
 	if err != nil {
 		return errors.Wrap(err, "accept connection")
 	}
-	defer closeConnection(c)
-	err = controller(ctx, c, numbers, terminate)
-	if err != nil {
-		if err == TERMINATED {
-			return err
+	serveConnection(ctx, c, controller, numbers, terminate, logger)
+	return nil
+}
+
+// serveConnection serves c with the controller and closes it. Canceling ctx aborts its reads, so the controller
+// stores the numbers already read and returns instead of waiting for more.
+func serveConnection(ctx context.Context, c net.Conn, controller TCPControllerV2, numbers chan *Batch,
+	terminate func(), logger *log.Logger) {
+	defer closeConnection(c, logger)
+	conn := &cancelableConn{Conn: c}
+	controlled, stopped := make(chan int), make(chan int)
+	defer func() {
+		close(controlled)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			if err := conn.cancel(); err != nil {
+				logger.Printf("%v", errors.Wrap(err, "cancel reads"))
+			}
+		case <-controlled:
 		}
-		log.Printf("%v", errors.Wrap(err, "controller error"))
+	}()
+	err := controller(ctx, conn, numbers)
+	switch {
+	case err == TERMINATED && ctx.Err() == nil:
+		terminate()
+	case err != nil && err != TERMINATED && ctx.Err() == nil:
+		logger.Printf("%v", errors.Wrap(err, "controller error"))
+	}
+}
+
+// cancelableConn is a connection whose reads, even the ones already blocked, fail once it is canceled.
+type cancelableConn struct {
+	net.Conn
+	mux      sync.Mutex
+	canceled bool
+}
+
+// cancel sets the read deadline in the past, the deadlines set after are ignored.
+func (c *cancelableConn) cancel() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.canceled = true
+	return c.Conn.SetReadDeadline(time.Unix(1, 0))
+}
+
+func (c *cancelableConn) SetReadDeadline(t time.Time) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.canceled {
 		return nil
 	}
-	return nil
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *cancelableConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.Conn.SetWriteDeadline(t)
 }
 
-func closeConnection(c net.Conn) {
+func closeConnection(c net.Conn, logger *log.Logger) {
 	if c != nil {
 		if err := c.Close(); err != nil {
-			log.Printf("%v", errors.Wrap(err, "closeConnection"))
+			logger.Printf("%v", errors.Wrap(err, "closeConnection"))
 		}
 	}
 }
@@ -2,6 +2,7 @@ package numbers_test
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"sync"
 	"testing"
@@ -21,6 +22,7 @@ func TestNewSingleConnectionListener(t *testing.T) {
 	go cnnListener(ctx, &mockListener{connection: server})
 	expectNumber(out, 98765432, t)
 	sendData(t, client, "terminate")
+	expectClosed(t, out)
 }
 
 func TestNewSingleConnectionListenerControllerReturnsErrorAndJustLogIt(t *testing.T) {
@@ -32,8 +34,23 @@ func TestNewSingleConnectionListenerControllerReturnsErrorAndJustLogIt(t *testin
 	sendData(t, client, expectedNumber)
 
 	terminate := make(chan int)
-	cnnListener, _ := numbers.NewSingleConnectionListener(numbers.DefaultTCPController, terminate)
+	cnnListener, out := numbers.NewSingleConnectionListener(numbers.DefaultTCPController, terminate)
 	go cnnListener(ctx, &mockListener{connection: server})
+	expectNumber(out, 98765432, t)
+	cancel()
+	expectClosed(t, out)
+}
+
+// expectClosed waits for the listener to return and close its numbers channel.
+func expectClosed(t *testing.T, out chan *numbers.Batch) {
+	select {
+	case _, more := <-out:
+		if more {
+			t.Fatal("no more numbers are expected")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout while waiting for the listener to return")
+	}
 }
 
 func newMockConnectionListener(t *testing.T, ctx context.Context, cancel context.CancelFunc) numbers.ConnectionListener {
@@ -45,7 +62,7 @@ func newMockConnectionListener(t *testing.T, ctx context.Context, cancel context
 		select {
 		case <-ticker.C:
 			cancel()
-			t.Fatal("expected to be closed")
+			t.Error("expected to be closed")
 		case <-ctx.Done():
 		}
 	}()
@@ -69,3 +86,80 @@ func (m *mockListener) Close() error {
 func (m *mockListener) Addr() net.Addr {
 	return nil
 }
+
+func TestNewSingleConnectionListenerV2CanceledWhileReading(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	cnnListener, out := numbers.NewSingleConnectionListenerV2(numbers.DefaultTCPControllerV2, cancel)
+	go cnnListener(ctx, l)
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	sendData(t, client, "098765432")
+	expectNumber(out, 98765432, t)
+	cancel()
+	expectClosed(t, out)
+}
+
+func TestNewSingleConnectionListenerV2Terminate(t *testing.T) {
+	server, client := net.Pipe()
+	sendData(t, client, "098765432\nterminate")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	terminated := make(chan int)
+	cnnListener, out := numbers.NewSingleConnectionListenerV2(numbers.DefaultTCPControllerV2, func() {
+		close(terminated)
+		cancel()
+	})
+	go cnnListener(ctx, &mockListener{connection: server})
+	expectNumber(out, 98765432, t)
+	expectClosed(t, out)
+	select {
+	case <-terminated:
+	default:
+		t.Fatal("terminate should be called")
+	}
+}
+
+func TestTCPControllerV2(t *testing.T) {
+	closing := numbers.TCPController(func(ctx context.Context, c net.Conn, numbers chan *numbers.Batch, terminate chan int) error {
+		close(terminate)
+		return nil
+	})
+	if err := closing.V2()(context.Background(), nil, nil); err != numbers.TERMINATED {
+		t.Fatal(fmt.Errorf("error should be: %v not %v", numbers.TERMINATED, err))
+	}
+	waiting := numbers.TCPController(func(ctx context.Context, c net.Conn, numbers chan *numbers.Batch, terminate chan int) error {
+		<-terminate
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := waiting.V2()(ctx, nil, nil); err != numbers.TERMINATED {
+		t.Fatal(fmt.Errorf("error should be: %v not %v", numbers.TERMINATED, err))
+	}
+}
+
+func TestStartServerReturnsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	returned := make(chan error, 1)
+	go func() {
+		returned <- numbers.StartServer(ctx, func(ctx context.Context, l net.Listener) { cancel() }, "localhost:0",
+			make(chan int))
+	}()
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout while waiting for the server to return")
+	}
+}
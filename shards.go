@@ -0,0 +1,159 @@
+package numbers
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Shards is a Deduplicator that splits the number space in consecutive ranges of the same size,
+// every range kept by its own Deduplicator. A sharded NumberStore deduplicates every range in its own goroutine.
+type Shards struct {
+	shards []Deduplicator
+	size   int
+	// owners are the goroutines deduplicating every shard while a NumberStore runs them.
+	owners []shardOwner
+	// locks are held by the goroutine of every shard while it deduplicates a batch, so the shards
+	// can be queried from other goroutines.
+	locks []sync.RWMutex
+	// firstSeen are the unix nanos every number was first seen at, per shard, when they are tracked.
+	firstSeen []map[int]int64
+}
+
+// shardOwner runs tasks in the goroutine owning a shard until it is done.
+type shardOwner struct {
+	tasks chan func()
+	done  chan int
+}
+
+// NewShards splits the number space between count Deduplicators created by factory.
+func NewShards(count int, factory DeduplicatorFactory) *Shards {
+	size := (maxNumbers + count - 1) / count
+	shards := make([]Deduplicator, count)
+	for i := range shards {
+		shards[i] = factory(size)
+	}
+	return &Shards{shards: shards, size: size, locks: make([]sync.RWMutex, count)}
+}
+
+// TrackFirstSeen makes the shards keep when every new number is first seen by a NumberStore, for FirstSeen.
+// It takes memory for every unique number, whatever the Deduplicator is.
+func (s *Shards) TrackFirstSeen() {
+	s.firstSeen = make([]map[int]int64, len(s.shards))
+	for i := range s.firstSeen {
+		s.firstSeen[i] = make(map[int]int64)
+	}
+}
+
+// Count returns how many shards there are.
+func (s *Shards) Count() int {
+	return len(s.shards)
+}
+
+// shard returns the shard owning the number.
+func (s *Shards) shard(number int) int {
+	return number / s.size
+}
+
+// TestAndSet adds the number to its shard and returns if it was already there.
+func (s *Shards) TestAndSet(number int) bool {
+	shard := s.shard(number)
+	return s.shards[shard].TestAndSet(number - shard*s.size)
+}
+
+// Contains returns if the number is in its shard, it can be called while a NumberStore runs the shards.
+func (s *Shards) Contains(number int) bool {
+	shard := s.shard(number)
+	s.locks[shard].RLock()
+	defer s.locks[shard].RUnlock()
+	return s.shards[shard].Contains(number - shard*s.size)
+}
+
+// FirstSeen returns when the number was first seen by a NumberStore, if the shards track it and it was seen.
+// It can be called while a NumberStore runs the shards.
+func (s *Shards) FirstSeen(number int) (time.Time, bool) {
+	if s.firstSeen == nil {
+		return time.Time{}, false
+	}
+	shard := s.shard(number)
+	s.locks[shard].RLock()
+	defer s.locks[shard].RUnlock()
+	seen, ok := s.firstSeen[shard][number]
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, seen), true
+}
+
+// Len returns how many numbers are in all the shards.
+func (s *Shards) Len() int {
+	length := 0
+	for _, shard := range s.shards {
+		length += shard.Len()
+	}
+	return length
+}
+
+// WriteTo writes how many shards there are followed by every shard.
+// While a NumberStore runs the shards, every shard is written from the goroutine owning it.
+func (s *Shards) WriteTo(w io.Writer) (int64, error) {
+	written, err := writeUint64s(w, uint64(len(s.shards)))
+	if err != nil {
+		return written, err
+	}
+	for i, shard := range s.shards {
+		var n int64
+		s.inShard(i, func() {
+			n, err = shard.WriteTo(w)
+		})
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// ReadFrom reads the shards written by WriteTo, they must have been written by the same number of shards.
+func (s *Shards) ReadFrom(r io.Reader) (int64, error) {
+	var count uint64
+	read, err := readUint64s(r, &count)
+	if err != nil {
+		return read, err
+	}
+	if count != uint64(len(s.shards)) {
+		return read, fmt.Errorf("there should be %d shards, not %d", len(s.shards), count)
+	}
+	for _, shard := range s.shards {
+		n, err := shard.ReadFrom(r)
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// kind describes the shards for the snapshots.
+func (s *Shards) kind() string {
+	return fmt.Sprintf("%d shards of %T", len(s.shards), s.shards[0])
+}
+
+// inShard runs the task in the goroutine owning the shard, if any, and waits for it.
+func (s *Shards) inShard(shard int, task func()) {
+	if s.owners == nil {
+		task()
+		return
+	}
+	finished := make(chan int)
+	select {
+	case s.owners[shard].tasks <- func() {
+		task()
+		close(finished)
+	}:
+		<-finished
+	case <-s.owners[shard].done:
+		task()
+	}
+}
@@ -0,0 +1,53 @@
+package numbers_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"tgracchus/numbers"
+)
+
+func TestShards(t *testing.T) {
+	shards := numbers.NewShards(3, func(size int) numbers.Deduplicator { return numbers.NewBitSet(size) })
+	for _, number := range []int{0, 333333333, 333333334, 666666667, maxNumbers - 1} {
+		if shards.TestAndSet(number) {
+			t.Fatal(fmt.Errorf("number %d should not be in the shards", number))
+		}
+		if !shards.TestAndSet(number) {
+			t.Fatal(fmt.Errorf("number %d should be in the shards", number))
+		}
+	}
+	if shards.Len() != 5 {
+		t.Fatal(fmt.Errorf("len should be: %d not %d", 5, shards.Len()))
+	}
+}
+
+func TestShardsSnapshotRoundTrip(t *testing.T) {
+	factory, err := numbers.NewDeduplicatorFactory(numbers.RoaringDeduplicator, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := numbers.NewShards(4, factory)
+	for n := 0; n < 10000; n++ {
+		shards.TestAndSet(n * spread % maxNumbers)
+	}
+	path := testFilePath(os.TempDir())
+	defer cleanUpFile(path)
+	if err := numbers.WriteSnapshot(path, numbers.Snapshot{Unique: 10000}, shards); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := numbers.NewShards(4, factory)
+	if _, err := numbers.ReadSnapshot(path, restored); err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < 10000; n++ {
+		if !restored.TestAndSet(n * spread % maxNumbers) {
+			t.Fatal(fmt.Errorf("number %d should have been restored", n*spread%maxNumbers))
+		}
+	}
+
+	if _, err := numbers.ReadSnapshotHeader(path, numbers.NewShards(2, factory)); err == nil {
+		t.Fatal("shard count mismatch error was expected")
+	}
+}
@@ -0,0 +1,82 @@
+package numbers
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrGracePeriodExpired is the error of a server terminated before its connections were drained.
+var ErrGracePeriodExpired = errors.New("grace period expired before the connections were drained")
+
+// shutdownOnSignal waits for the server to stop. On a signal the server is shut down, its connections are drained
+// until they are closed by their clients or the grace period expires, or until a second signal, and then
+// the server is terminated as a terminate line does.
+// Returns the error of the server, or ErrGracePeriodExpired or ErrShutdownCanceled if the connections were not drained.
+func shutdownOnSignal(server *Server, signals chan os.Signal, gracePeriod time.Duration) error {
+	select {
+	case <-server.done:
+		return server.Wait()
+	case sig := <-signals:
+		server.logger.Printf("%v received, draining the connections for up to %v", sig, gracePeriod)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	go func() {
+		select {
+		case sig := <-signals:
+			server.logger.Printf("%v received again, terminating now", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return server.Shutdown(ctx)
+}
+
+// terminateMux makes closing a terminate channel from several goroutines safe.
+var terminateMux sync.Mutex
+
+// closeTerminate closes terminate unless it is already closed.
+func closeTerminate(terminate chan int) {
+	terminateMux.Lock()
+	defer terminateMux.Unlock()
+	if !isClosed(terminate) {
+		close(terminate)
+	}
+}
+
+// isClosed returns if terminate is closed.
+func isClosed(terminate chan int) bool {
+	select {
+	case <-terminate:
+		return true
+	default:
+		return false
+	}
+}
+
+// contextUntilClosed returns a context canceled once terminate is closed, for the APIs still terminated
+// by a channel. The context must be canceled once done with it.
+func contextUntilClosed(parent context.Context, terminate chan int) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-terminate:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// whenClosed returns a channel closed once terminate is.
+func whenClosed(terminate chan int) <-chan struct{} {
+	closed := make(chan struct{})
+	go func() {
+		<-terminate
+		close(closed)
+	}()
+	return closed
+}
@@ -0,0 +1,223 @@
+package numbers_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"tgracchus/numbers"
+	"time"
+)
+
+func TestStartNumberServerDrainsOnSignal(t *testing.T) {
+	stopped, conn := startTestServer(t, numbers.Options{Address: "localhost:4100", GracePeriod: time.Minute})
+	if _, err := conn.Write([]byte("000000001\n000000002\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := conn.Write([]byte("000000003\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout while waiting for the server to drain")
+	}
+	expectLogNumbers(t, "000000001", "000000002", "000000003")
+}
+
+func TestStartNumberServerGracePeriodExpires(t *testing.T) {
+	stopped, conn := startTestServer(t, numbers.Options{Address: "localhost:4101", GracePeriod: 100 * time.Millisecond})
+	defer conn.Close()
+	if _, err := conn.Write([]byte("000000001\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-stopped:
+		if err != numbers.ErrGracePeriodExpired {
+			t.Fatal(fmt.Errorf("error should be: %v not %v", numbers.ErrGracePeriodExpired, err))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout while waiting for the server to terminate")
+	}
+}
+
+func TestTerminateStoresEveryAcceptedNumber(t *testing.T) {
+	const clients = 4
+	address := "localhost:4102"
+	reports := make(chanReporter, 100)
+	stopped, conn := startTestServer(t, numbers.Options{ConcurrentConnections: clients + 1, Address: address, Ack: true,
+		Reporters: []numbers.Reporter{reports}})
+	conn.Close()
+
+	acked := make(chan []string, clients)
+	for client := 0; client < clients; client++ {
+		go func(client int) {
+			acked <- sendUntilTerminated(t, address, client*100000000)
+		}(client)
+	}
+	time.Sleep(100 * time.Millisecond)
+	terminator, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := terminator.Write([]byte("terminate\n")); err != nil {
+		t.Fatal(err)
+	}
+	terminator.Close()
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout while waiting for the server to terminate")
+	}
+	var expected []string
+	for client := 0; client < clients; client++ {
+		expected = append(expected, <-acked...)
+	}
+	if len(expected) == 0 {
+		t.Fatal("no number was acknowledged before terminate")
+	}
+	sort.Strings(expected)
+	expectLogNumbers(t, expected...)
+	close(reports)
+	var final numbers.Report
+	for report := range reports {
+		if report.Final {
+			final = report
+		}
+	}
+	if !final.Final {
+		t.Fatal("no final report was sent")
+	}
+	if final.UniqueTotal != int64(len(expected)) || final.Total != int64(len(expected)) {
+		t.Fatal(fmt.Errorf("final report should count %d numbers and %d unique ones, not %d and %d",
+			len(expected), len(expected), final.Total, final.UniqueTotal))
+	}
+}
+
+// sendUntilTerminated sends numbers from first on, in chunks waiting for their acknowledgements,
+// until the server closes the connection. Returns the numbers acknowledged as new.
+func sendUntilTerminated(t *testing.T, address string, first int) []string {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	var acked []string
+	for number := first; ; {
+		var chunk []string
+		for i := 0; i < 100; i, number = i+1, number+1 {
+			chunk = append(chunk, fmt.Sprintf("%09d", number))
+		}
+		if _, err := conn.Write([]byte(strings.Join(chunk, "\n") + "\n")); err != nil {
+			return acked
+		}
+		for _, line := range chunk {
+			reply, err := reader.ReadString('\n')
+			if err != nil {
+				return acked
+			}
+			if reply != "NEW\n" {
+				t.Error(fmt.Errorf("reply to %s should be: NEW not %q", line, reply))
+			}
+			acked = append(acked, line)
+		}
+	}
+}
+
+// startTestServer starts a number server with the options in a temporary directory and connects to it.
+// Returns the channel with the error of the server once it stops and the connection.
+func startTestServer(t *testing.T, options numbers.Options) (chan error, net.Conn) {
+	dir, err := ioutil.TempDir("", "numbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	})
+	if options.ConcurrentConnections == 0 {
+		options.ConcurrentConnections = 2
+	}
+	options.Dedup = func(size int) numbers.Deduplicator { return numbers.NewMapSet() }
+	options.Shards = 2
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- numbers.StartNumberServer(options)
+	}()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", options.Address); err == nil {
+			return stopped, conn
+		}
+	}
+	t.Fatal("timeout while connecting to the server")
+	return nil, nil
+}
+
+// expectLogNumbers checks the number log in the working directory holds the numbers, in any order.
+func expectLogNumbers(t *testing.T, expected ...string) {
+	content, err := ioutil.ReadFile("numbers.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logged := strings.Fields(string(content))
+	sort.Strings(logged)
+	if strings.Join(logged, " ") != strings.Join(expected, " ") {
+		t.Fatal(fmt.Errorf("numbers.log should hold: %v not %v", expected, logged))
+	}
+}
+
+func TestStartNumberServerReloadsOnSignal(t *testing.T) {
+	reload := func() (numbers.Options, error) {
+		return numbers.Options{ConcurrentConnections: 3}, nil
+	}
+	stopped, conn := startTestServer(t, numbers.Options{Address: "localhost:4116", HTTPAddress: "localhost:4117",
+		Reload: reload})
+	defer conn.Close()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	var stats numbers.Stats
+	for start := time.Now(); stats.ConnectionLimit != 3; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal(fmt.Errorf("connection limit should be: 3 not %d", stats.ConnectionLimit))
+		}
+		stats = getStats(t, "http://localhost:4117/stats")
+	}
+	if _, err := conn.Write([]byte("terminate\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,242 @@
+package numbers
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const snapshotMagic = "NSNP"
+const snapshotVersion = 1
+
+// Snapshot is the state of a NumberStore at a point of the number log.
+// The unique numbers themselves are written and read by the Deduplicator.
+type Snapshot struct {
+	// Total numbers received.
+	Total int64
+	// Unique numbers received.
+	Unique int64
+	// LogOffset is the size of the number log when the snapshot was taken, every number before it is in the snapshot.
+	LogOffset int64
+}
+
+// WriteSnapshot writes the snapshot and the numbers of the deduplicator to path.
+// It is written to a temporary file first and then renamed, so path always holds a complete snapshot.
+func WriteSnapshot(path string, snapshot Snapshot, numbers Deduplicator) error {
+	return writeSnapshot(path, snapshot, numbers, nil, standardLogger)
+}
+
+// writeSnapshot works as WriteSnapshot and calls beforeRename, if any, once the snapshot is written
+// but before it replaces the one at path.
+func writeSnapshot(path string, snapshot Snapshot, numbers Deduplicator, beforeRename func() error,
+	logger *log.Logger) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "create snapshot")
+	}
+	defer os.Remove(tmp.Name())
+	defer closeLog(tmp, logger)
+
+	checksum := crc32.NewIEEE()
+	b := bufio.NewWriter(io.MultiWriter(tmp, checksum))
+	if err := writeSnapshotHeader(b, snapshot, numbers); err != nil {
+		return errors.Wrap(err, "write snapshot header")
+	}
+	if _, err := numbers.WriteTo(b); err != nil {
+		return errors.Wrap(err, "write snapshot numbers")
+	}
+	if err := b.Flush(); err != nil {
+		return errors.Wrap(err, "flush snapshot")
+	}
+	if err := binary.Write(tmp, binary.LittleEndian, checksum.Sum32()); err != nil {
+		return errors.Wrap(err, "write snapshot checksum")
+	}
+	if err := tmp.Sync(); err != nil {
+		return errors.Wrap(err, "sync snapshot")
+	}
+	if beforeRename != nil {
+		if err := beforeRename(); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrap(err, "rename snapshot")
+	}
+	return nil
+}
+
+// ReadSnapshotHeader reads the snapshot at path without its numbers, so it can be checked before loading it.
+func ReadSnapshotHeader(path string, numbers Deduplicator) (Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer f.Close()
+	return readSnapshotHeader(bufio.NewReader(f), numbers)
+}
+
+// ReadSnapshot reads the snapshot at path and loads its numbers into the given, empty, deduplicator.
+// The deduplicator must be of the same kind as the one that wrote the snapshot.
+func ReadSnapshot(path string, numbers Deduplicator) (Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer f.Close()
+
+	checksum := crc32.NewIEEE()
+	info, err := f.Stat()
+	if err != nil {
+		return Snapshot{}, errors.Wrap(err, "stat snapshot")
+	}
+	body := io.TeeReader(bufio.NewReader(io.LimitReader(f, info.Size()-4)), checksum)
+	snapshot, err := readSnapshotHeader(body, numbers)
+	if err != nil {
+		return snapshot, err
+	}
+	if _, err := numbers.ReadFrom(body); err != nil {
+		return snapshot, errors.Wrap(err, "read snapshot numbers")
+	}
+	if _, err := io.Copy(ioutil.Discard, body); err != nil {
+		return snapshot, errors.Wrap(err, "read snapshot")
+	}
+	var expected uint32
+	if err := binary.Read(f, binary.LittleEndian, &expected); err != nil {
+		return snapshot, errors.Wrap(err, "read snapshot checksum")
+	}
+	if expected != checksum.Sum32() {
+		return snapshot, fmt.Errorf("snapshot %s checksum mismatch", path)
+	}
+	return snapshot, nil
+}
+
+func writeSnapshotHeader(w io.Writer, snapshot Snapshot, numbers Deduplicator) error {
+	kind := deduplicatorKind(numbers)
+	if _, err := io.WriteString(w, snapshotMagic); err != nil {
+		return err
+	}
+	if _, err := writeUint64s(w, snapshotVersion, uint64(len(kind))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, kind); err != nil {
+		return err
+	}
+	_, err := writeUint64s(w, uint64(snapshot.Total), uint64(snapshot.Unique), uint64(snapshot.LogOffset))
+	return err
+}
+
+func readSnapshotHeader(r io.Reader, numbers Deduplicator) (Snapshot, error) {
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return Snapshot{}, errors.Wrap(err, "read snapshot magic")
+	}
+	if string(magic) != snapshotMagic {
+		return Snapshot{}, fmt.Errorf("not a snapshot, magic %q", magic)
+	}
+	var version, kindLength uint64
+	if _, err := readUint64s(r, &version, &kindLength); err != nil {
+		return Snapshot{}, errors.Wrap(err, "read snapshot version")
+	}
+	if version != snapshotVersion {
+		return Snapshot{}, fmt.Errorf("snapshot version should be %d, not %d", snapshotVersion, version)
+	}
+	if kindLength > 256 {
+		return Snapshot{}, fmt.Errorf("snapshot deduplicator kind too long, %d bytes", kindLength)
+	}
+	kind := make([]byte, kindLength)
+	if _, err := io.ReadFull(r, kind); err != nil {
+		return Snapshot{}, errors.Wrap(err, "read snapshot deduplicator kind")
+	}
+	if expected := deduplicatorKind(numbers); string(kind) != expected {
+		return Snapshot{}, fmt.Errorf("snapshot deduplicator should be %s, not %s", expected, kind)
+	}
+	var total, unique, logOffset uint64
+	if _, err := readUint64s(r, &total, &unique, &logOffset); err != nil {
+		return Snapshot{}, errors.Wrap(err, "read snapshot counters")
+	}
+	return Snapshot{Total: int64(total), Unique: int64(unique), LogOffset: int64(logOffset)}, nil
+}
+
+// deduplicatorKind identifies the implementation of numbers, so a snapshot is only loaded into the same one.
+func deduplicatorKind(numbers Deduplicator) string {
+	if shards, ok := numbers.(*Shards); ok {
+		return shards.kind()
+	}
+	return fmt.Sprintf("%T", numbers)
+}
+
+// writeUint64s writes the values little endian.
+func writeUint64s(w io.Writer, values ...uint64) (int64, error) {
+	buf := make([]byte, 8*len(values))
+	for i, value := range values {
+		binary.LittleEndian.PutUint64(buf[8*i:], value)
+	}
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// readUint64s reads little endian values.
+func readUint64s(r io.Reader, values ...*uint64) (int64, error) {
+	buf := make([]byte, 8*len(values))
+	n, err := io.ReadFull(r, buf)
+	if err != nil {
+		return int64(n), err
+	}
+	for i, value := range values {
+		*value = binary.LittleEndian.Uint64(buf[8*i:])
+	}
+	return int64(n), nil
+}
+
+const wordsChunk = 8192
+
+// writeWords writes the words little endian in chunks, not to copy big bit arrays at once.
+func writeWords(w io.Writer, words []uint64) (int64, error) {
+	var written int64
+	buf := make([]byte, 8*wordsChunk)
+	for len(words) > 0 {
+		chunk := words
+		if len(chunk) > wordsChunk {
+			chunk = chunk[:wordsChunk]
+		}
+		for i, word := range chunk {
+			binary.LittleEndian.PutUint64(buf[8*i:], word)
+		}
+		n, err := w.Write(buf[:8*len(chunk)])
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		words = words[len(chunk):]
+	}
+	return written, nil
+}
+
+// readWords fills words with the little endian words read in chunks.
+func readWords(r io.Reader, words []uint64) (int64, error) {
+	var read int64
+	buf := make([]byte, 8*wordsChunk)
+	for len(words) > 0 {
+		chunk := words
+		if len(chunk) > wordsChunk {
+			chunk = chunk[:wordsChunk]
+		}
+		n, err := io.ReadFull(r, buf[:8*len(chunk)])
+		read += int64(n)
+		if err != nil {
+			return read, err
+		}
+		for i := range chunk {
+			chunk[i] = binary.LittleEndian.Uint64(buf[8*i:])
+		}
+		words = words[len(chunk):]
+	}
+	return read, nil
+}
@@ -0,0 +1,249 @@
+package numbers_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"tgracchus/numbers"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, name := range []string{numbers.MapDeduplicator, numbers.BitSetDeduplicator,
+		numbers.RoaringDeduplicator, numbers.BloomDeduplicator} {
+		t.Run(name, func(t *testing.T) {
+			dedup := newTestDeduplicator(t, name)
+			for n := 0; n < 10000; n++ {
+				dedup.TestAndSet(n * spread % maxNumbers)
+			}
+			for n := 0; n < 70000; n++ {
+				dedup.TestAndSet(n)
+			}
+			path := testFilePath(os.TempDir())
+			defer cleanUpFile(path)
+			expected := numbers.Snapshot{Total: 90000, Unique: int64(dedup.Len()), LogOffset: 10 * int64(dedup.Len())}
+			if err := numbers.WriteSnapshot(path, expected, dedup); err != nil {
+				t.Fatal(err)
+			}
+
+			restored := newTestDeduplicator(t, name)
+			snapshot, err := numbers.ReadSnapshot(path, restored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if snapshot != expected {
+				t.Fatal(fmt.Errorf("snapshot should be: %+v not %+v", expected, snapshot))
+			}
+			if restored.Len() != dedup.Len() {
+				t.Fatal(fmt.Errorf("len should be: %d not %d", dedup.Len(), restored.Len()))
+			}
+			for n := 0; n < 10000; n++ {
+				if !restored.TestAndSet(n * spread % maxNumbers) {
+					t.Fatal(fmt.Errorf("number %d should have been restored", n*spread%maxNumbers))
+				}
+			}
+		})
+	}
+}
+
+func TestReadSnapshotChecksumMismatch(t *testing.T) {
+	path := testFilePath(os.TempDir())
+	defer cleanUpFile(path)
+	dedup := numbers.NewMapSet()
+	dedup.TestAndSet(123456789)
+	if err := numbers.WriteSnapshot(path, numbers.Snapshot{Total: 1, Unique: 1, LogOffset: 10}, dedup); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[len(content)-5] ^= 0xff
+	if err := ioutil.WriteFile(path, content, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := numbers.ReadSnapshot(path, numbers.NewMapSet()); err == nil {
+		t.Fatal("checksum mismatch error was expected")
+	}
+}
+
+func TestReadSnapshotHeaderOtherDeduplicator(t *testing.T) {
+	path := testFilePath(os.TempDir())
+	defer cleanUpFile(path)
+	if err := numbers.WriteSnapshot(path, numbers.Snapshot{}, numbers.NewMapSet()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := numbers.ReadSnapshotHeader(path, numbers.NewRoaringBitmap()); err == nil {
+		t.Fatal("deduplicator mismatch error was expected")
+	}
+}
+
+func TestResumeNumberLogFrom(t *testing.T) {
+	filePath := writeTestLog(t, "000000001\n000000002\n000000003\n")
+	defer cleanUpFile(filePath)
+
+	dedup := numbers.NewMapSet()
+	loaded, err := numbers.ResumeNumberLogFrom(filePath, 20, dedup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 1 || !dedup.TestAndSet(3) {
+		t.Fatal("only the number after the offset should have been loaded")
+	}
+}
+
+func TestServerResumesTotalFromSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "numbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	reports := make(chanReporter, 100)
+	options := numbers.Options{
+		Address:        "localhost:0",
+		OutputPath:     filepath.Join(dir, "numbers.log"),
+		SnapshotPath:   filepath.Join(dir, "numbers.snapshot"),
+		SnapshotPeriod: 10 * time.Millisecond,
+		Dedup:          func(size int) numbers.Deduplicator { return numbers.NewMapSet() },
+		Shards:         2,
+		Ack:            true,
+		Reporters:      []numbers.Reporter{reports},
+	}
+	server := numbers.NewServer(options)
+	if err := server.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	expectReplies := func(lines string, replies ...string) {
+		if _, err := conn.Write([]byte(lines)); err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range replies {
+			if reply, err := reader.ReadString('\n'); err != nil || reply != expected {
+				t.Fatal(fmt.Errorf("reply should be: %q not %q, %v", expected, reply, err))
+			}
+		}
+	}
+	expectReplies("000000001\n000000002\n000000001\n", "NEW\n", "NEW\n", "DUP\n")
+	var snapshot []byte
+	for start := time.Now(); snapshot == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("timeout while waiting for a snapshot of the numbers")
+		}
+		header, err := numbers.ReadSnapshotHeader(options.SnapshotPath, numbers.NewShards(2, options.Dedup))
+		if err == nil && header.Total == 3 {
+			if snapshot, err = ioutil.ReadFile(options.SnapshotPath); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	expectReplies("000000003\n000000004\n", "NEW\n", "NEW\n")
+	conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(options.SnapshotPath, snapshot, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	options.Resume = true
+	options.SnapshotPeriod = 0
+	reports = make(chanReporter, 100)
+	options.Reporters = []numbers.Reporter{reports}
+	server = numbers.NewServer(options)
+	if err := server.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	close(reports)
+	var final numbers.Report
+	for report := range reports {
+		final = report
+	}
+	if !final.Final || final.Total != 5 || final.UniqueTotal != 4 {
+		t.Fatal(fmt.Errorf("resumed final report should count 5 numbers and 4 unique ones, not %+v", final))
+	}
+}
+
+func TestSnapshotTotalMatchesLogOffset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "numbers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	options := numbers.Options{
+		Address:        "localhost:0",
+		OutputPath:     filepath.Join(dir, "numbers.log"),
+		SnapshotPath:   filepath.Join(dir, "numbers.snapshot"),
+		SnapshotPeriod: time.Millisecond,
+		Dedup:          func(size int) numbers.Deduplicator { return numbers.NewMapSet() },
+		Shards:         2,
+	}
+	server := numbers.NewServer(options)
+	if err := server.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := make(chan error, 1)
+	go func() {
+		w := bufio.NewWriter(conn)
+		for number := 0; number < 50000; number++ {
+			if _, err := fmt.Fprintf(w, "%09d\n", number); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- w.Flush()
+	}()
+	shards := numbers.NewShards(2, options.Dedup)
+	for checked, start := 0, time.Now(); checked < 20; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("timeout while waiting for the snapshots")
+		}
+		header, err := numbers.ReadSnapshotHeader(options.SnapshotPath, shards)
+		if err != nil {
+			continue
+		}
+		if header.Total*10 != header.LogOffset {
+			t.Fatal(fmt.Errorf("snapshot total should be the %d numbers before its log offset, not %d",
+				header.LogOffset/10, header.Total))
+		}
+		checked++
+	}
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestDeduplicator(t *testing.T, name string) numbers.Deduplicator {
+	dedup, err := numbers.NewDeduplicator(name, 100000, 0.0001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dedup
+}
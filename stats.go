@@ -0,0 +1,189 @@
+package numbers
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// shardStats are the counters of a shard, updated by the shard and read by the reports.
+type shardStats struct {
+	total             int64
+	uniqueTotal       int64
+	currentUnique     int64
+	currentDuplicated int64
+	// padding keeps the counters of every shard in their own cache line.
+	_ [4]int64
+}
+
+// storeCounters are the counters of a NumberStore, updated by its shards without locking and read at any time
+// by the reports and the stats.
+type storeCounters struct {
+	// restored are the numbers received before the server started, replayed from the number log.
+	restored int64
+	// restoredUnique are the unique numbers the shards were started with.
+	restoredUnique int64
+	// routed are the numbers routed to the shards, the ones not deduplicated yet are in flight.
+	routed int64
+	shards []shardStats
+}
+
+func newStoreCounters(restored int64, shards int) *storeCounters {
+	return &storeCounters{restored: restored, shards: make([]shardStats, shards)}
+}
+
+// totals returns the numbers received and how many of them are unique.
+func (c *storeCounters) totals() (int64, int64) {
+	total, unique := c.restored, int64(0)
+	for i := range c.shards {
+		total += atomic.LoadInt64(&c.shards[i].total)
+		unique += atomic.LoadInt64(&c.shards[i].uniqueTotal)
+	}
+	return total, unique
+}
+
+// duplicates returns the duplicated numbers received, they are never in the number log. Every shard is read
+// in the goroutine owning it, so its total and unique counters are from the same batch.
+func (c *storeCounters) duplicates(shards *Shards) int64 {
+	duplicates := c.restored
+	for i := range c.shards {
+		shards.inShard(i, func() {
+			duplicates += atomic.LoadInt64(&c.shards[i].total) - atomic.LoadInt64(&c.shards[i].uniqueTotal)
+		})
+	}
+	return duplicates
+}
+
+// sinceStart returns the numbers received since the server started and how many of them are unique.
+func (c *storeCounters) sinceStart() (int64, int64) {
+	total, unique := c.totals()
+	return total - c.restored, unique - atomic.LoadInt64(&c.restoredUnique)
+}
+
+// window returns the unique and duplicated numbers received in the current report window.
+func (c *storeCounters) window() (int64, int64) {
+	var unique, duplicated int64
+	for i := range c.shards {
+		unique += atomic.LoadInt64(&c.shards[i].currentUnique)
+		duplicated += atomic.LoadInt64(&c.shards[i].currentDuplicated)
+	}
+	return unique, duplicated
+}
+
+// swapWindow works as window and starts a new report window.
+func (c *storeCounters) swapWindow() (int64, int64) {
+	var unique, duplicated int64
+	for i := range c.shards {
+		unique += atomic.SwapInt64(&c.shards[i].currentUnique, 0)
+		duplicated += atomic.SwapInt64(&c.shards[i].currentDuplicated, 0)
+	}
+	return unique, duplicated
+}
+
+// States of a number server, as reported by its stats and readiness.
+const (
+	// StateResuming is the state while the number log is loaded, before accepting connections.
+	StateResuming = "resuming"
+	// StateServing is the state while accepting connections.
+	StateServing = "serving"
+	// StateDraining is the state once terminated, while the numbers in flight are stored.
+	StateDraining = "draining"
+)
+
+// Stats are the statistics of a number server at a point in time.
+type Stats struct {
+	State string `json:"state"`
+	// Total are the numbers received, Unique the ones not duplicated.
+	Total  int64 `json:"total"`
+	Unique int64 `json:"unique"`
+	// WindowUnique and WindowDuplicates are the numbers received in the current report window.
+	WindowUnique      int64 `json:"window_unique"`
+	WindowDuplicates  int64 `json:"window_duplicates"`
+	ActiveConnections int64 `json:"active_connections"`
+	// ConnectionLimit is how many connections are served at the same time, it changes when resized.
+	ConnectionLimit int64 `json:"connection_limit"`
+	// QueuedConnections and RejectedConnections are the connections over capacity queued and rejected so far.
+	QueuedConnections   int64         `json:"queued_connections"`
+	RejectedConnections int64         `json:"rejected_connections"`
+	Rejected            RejectedStats `json:"rejected"`
+	UptimeSeconds       float64       `json:"uptime_seconds"`
+}
+
+// RejectedStats are the lines rejected for every reason and the clients disconnected for too many of them.
+type RejectedStats struct {
+	WrongLength    int64 `json:"wrong_length"`
+	NonDigit       int64 `json:"non_digit"`
+	Sign           int64 `json:"sign"`
+	MissingNewLine int64 `json:"missing_new_line"`
+	Disconnected   int64 `json:"disconnected"`
+}
+
+// status is the state of a number server, shared with the goroutines reporting it.
+type status struct {
+	started time.Time
+	state   atomic.Value
+	// accepted and closed count the connections, the active ones are the accepted not closed yet.
+	accepted int64
+	closed   int64
+	// queued and rejected count the connections over capacity, waiting for a worker and replied ErrBusy.
+	queued   int64
+	rejected int64
+	// limit is how many connections are served at the same time.
+	limit int64
+	// counters are set once the NumberStore is started.
+	counters   atomic.Value
+	rejections *Rejections
+	writer     *writerMetrics
+}
+
+func newStatus(rejections *Rejections) *status {
+	s := &status{started: time.Now(), rejections: rejections, writer: newWriterMetrics()}
+	s.state.Store(StateResuming)
+	return s
+}
+
+func (s *status) setState(state string) {
+	s.state.Store(state)
+}
+
+// ready returns if the server is accepting connections.
+func (s *status) ready() bool {
+	return s.state.Load().(string) == StateServing
+}
+
+// stats returns the current stats, reading them does not block the NumberStore.
+func (s *status) stats() Stats {
+	stats := Stats{
+		State:               s.state.Load().(string),
+		ActiveConnections:   atomic.LoadInt64(&s.accepted) - atomic.LoadInt64(&s.closed),
+		ConnectionLimit:     atomic.LoadInt64(&s.limit),
+		QueuedConnections:   atomic.LoadInt64(&s.queued),
+		RejectedConnections: atomic.LoadInt64(&s.rejected),
+		UptimeSeconds:       time.Since(s.started).Seconds(),
+	}
+	if counters, ok := s.counters.Load().(*storeCounters); ok {
+		stats.Total, stats.Unique = counters.totals()
+		stats.WindowUnique, stats.WindowDuplicates = counters.window()
+	}
+	if s.rejections != nil {
+		rejected := s.rejections.all()
+		stats.Rejected = RejectedStats{
+			WrongLength:    rejected[0],
+			NonDigit:       rejected[1],
+			Sign:           rejected[2],
+			MissingNewLine: rejected[3],
+			Disconnected:   s.rejections.Disconnected(),
+		}
+	}
+	return stats
+}
+
+// countConnections returns a TCPControllerV2 that runs controller and counts its connections in the status.
+func (s *status) countConnections(controller TCPControllerV2) TCPControllerV2 {
+	return func(ctx context.Context, c net.Conn, numbers chan *Batch) error {
+		atomic.AddInt64(&s.accepted, 1)
+		defer atomic.AddInt64(&s.closed, 1)
+		return controller(ctx, c, numbers)
+	}
+}